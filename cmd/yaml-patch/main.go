@@ -4,92 +4,318 @@ import (
 	"flag"
 	"fmt"
 	"github.com/grafana/k8s-diff/pkg/differ"
+	"github.com/grafana/k8s-diff/pkg/lsp"
 	"os"
+	"strings"
 
 	"github.com/grafana/dskit/flagext"
-	"gopkg.in/yaml.v2"
 )
 
 type Config struct {
 	RuleFiles      flagext.StringSlice
+	RulesDir       flagext.StringSlice
+	Vars           flagext.StringSlice
+	JPath          flagext.StringSlice
+	ExtVars        flagext.StringSlice
+	ListRules      bool
 	InputDir       flagext.StringSlice
 	OutputDir      flagext.StringSlice
 	OutputTemplate string
 	PrintTodo      bool
+	Diff           bool
+	DetectRenames  bool
+	RenameMinScore float64
+	Suggest        bool
+	Matrix         bool
+	Baseline       string
+	WriteBaseline  string
+	ShowSecrets    bool
 }
 
 func (c *Config) RegisterFlags(f *flag.FlagSet) {
-	f.Var(&c.RuleFiles, "rules", "Rule file to load, can be specified multiple times")
-	f.Var(&c.InputDir, "input-dir", "Input directory, can be specified multiple times - must have the same number of elements as output-dir")
+	f.Var(&c.RuleFiles, "rules", "Rule file to load, YAML or Jsonnet, can be specified multiple times")
+	f.Var(&c.RulesDir, "rules-dir", "Directory of rule files to load in sorted order after the files given with -rules, can be specified multiple times")
+	f.Var(&c.Vars, "var", "Set a var used in rule files as key=value, overriding the vars declared in rule files, can be specified multiple times")
+	f.Var(&c.JPath, "jpath", "Library search path for Jsonnet rule files, can be specified multiple times")
+	f.Var(&c.ExtVars, "ext-str", "Set an external variable of Jsonnet rule files as key=value, can be specified multiple times")
+	f.BoolVar(&c.ListRules, "list-rules", false, "Print every rule in the order it runs with the file it was loaded from, then exit")
+	f.Var(&c.InputDir, "input-dir", "Input directory, optionally named as name=dir for rules with inputs, can be specified multiple times - must have the same number of elements as output-dir")
 	f.Var(&c.OutputDir, "output-dir", "Output directory, can be specified multiple times - must have the same number of elements as input-dir")
 	f.StringVar(&c.OutputTemplate, "output-template", "", "Template used to generate output file names.")
 	f.BoolVar(&c.PrintTodo, "print-todo", false, "Print the diffs for any objects impacted by rules with todo: true")
+	f.BoolVar(&c.Diff, "diff", false, "Print a structural diff between the two input directories after applying the rules, output-dir is optional in this mode")
+	f.BoolVar(&c.DetectRenames, "detect-renames", false, "Print rename_object rules pairing the objects only present in one of the two input directories, output-dir is optional in this mode")
+	f.Float64Var(&c.RenameMinScore, "rename-min-score", 0.5, "Minimum confidence between 0 and 1 for a rename to be suggested by -detect-renames")
+	f.BoolVar(&c.Suggest, "suggest", false, "Print todo patch rules that would eliminate the remaining differences between the two input directories, output-dir is optional in this mode")
+	f.StringVar(&c.Baseline, "baseline", "", "Baseline file of accepted differences between the two input directories, fails if any other difference remains, output-dir is optional in this mode")
+	f.StringVar(&c.WriteBaseline, "write-baseline", "", "Record the remaining differences between the two input directories in this baseline file, output-dir is optional in this mode")
+	f.BoolVar(&c.ShowSecrets, "show-secrets", false, "Show the decoded values of Secret data in differences and the matrix instead of redacting them")
+	f.BoolVar(&c.Matrix, "matrix", false, "Print a table of every field on which the input directories don't all agree, output-dir is optional in this mode")
+}
+
+// ComparesInputs returns true if yaml-patch runs in a mode comparing the two
+// input directories with each other.
+func (c *Config) ComparesInputs() bool {
+	return c.Diff || c.DetectRenames || c.Suggest || c.Baseline != "" || c.WriteBaseline != ""
+}
+
+// OutputIsOptional returns true if yaml-patch runs in a mode printing a report
+// rather than only writing the patched objects.
+func (c *Config) OutputIsOptional() bool {
+	return c.ComparesInputs() || c.Matrix
+}
+
+// Inputs returns the name and directory of each input-dir. Inputs without a
+// name are named after their directory.
+func (c *Config) Inputs() (names, dirs []string, err error) {
+	seen := map[string]bool{}
+	for _, v := range c.InputDir {
+		name, dir := v, v
+		if i := strings.Index(v, "="); i >= 0 {
+			name, dir = v[:i], v[i+1:]
+		}
+		if name == "" || dir == "" {
+			return nil, nil, fmt.Errorf("invalid input-dir %q, expected dir or name=dir", v)
+		}
+		if seen[name] {
+			return nil, nil, fmt.Errorf("input %q is specified more than once", name)
+		}
+		seen[name] = true
+		names = append(names, name)
+		dirs = append(dirs, dir)
+	}
+	return names, dirs, nil
 }
 
 func (c *Config) LoadRuleSet() (differ.RuleSet, error) {
 	ruleSet := differ.RuleSet{}
+	loader := differ.NewRuleLoader()
+	loader.Vars = map[string]string{}
+	for _, v := range c.Vars {
+		key, value, err := differ.ParseVar(v)
+		if err != nil {
+			return ruleSet, err
+		}
+		loader.Vars[key] = value
+	}
+	loader.JPath = c.JPath
+	loader.ExtVars = map[string]string{}
+	for _, v := range c.ExtVars {
+		key, value, err := differ.ParseVar(v)
+		if err != nil {
+			return ruleSet, err
+		}
+		loader.ExtVars[key] = value
+	}
 	for _, v := range c.RuleFiles {
-		subRules := &differ.RuleSet{}
-		ruleFile, err := os.Open(v)
+		subRules, err := loader.LoadFile(v)
 		if err != nil {
-			return ruleSet, fmt.Errorf("failed to open rule file: %v", err)
+			return ruleSet, err
 		}
-		defer ruleFile.Close()
-		err = yaml.NewDecoder(ruleFile).Decode(subRules)
+		ruleSet.Merge(subRules)
+	}
+	for _, v := range c.RulesDir {
+		subRules, err := loader.LoadDir(v)
 		if err != nil {
-			return ruleSet, fmt.Errorf("failed to decode rule file: %v", err)
+			return ruleSet, err
 		}
-
 		ruleSet.Merge(subRules)
 	}
+	if err := ruleSet.ValidatePhaseOrder(); err != nil {
+		return ruleSet, err
+	}
 	ruleSet.Desugar()
 	return ruleSet, nil
 }
 
+// checkRuleInputs fails if a rule is restricted to an input which doesn't
+// exist, since the rule would silently never apply.
+func checkRuleInputs(ruleSet differ.RuleSet, inputNames []string) error {
+	known := map[string]bool{}
+	for _, name := range inputNames {
+		known[name] = true
+	}
+	for _, rule := range ruleSet.ObjectRules() {
+		for _, name := range rule.Describe().Inputs {
+			if !known[name] {
+				return fmt.Errorf("rule %q refers to unknown input %q", rule.Describe().Name, name)
+			}
+		}
+	}
+	return nil
+}
+
+// serveLSP runs the language server for rule files over stdio. It takes the
+// same flags as yaml-patch to load the rules and inputs.
+func serveLSP(args []string) error {
+	var config = &Config{}
+	flags := flag.NewFlagSet("yaml-patch lsp", flag.ExitOnError)
+	config.RegisterFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	load := func() (differ.RuleSet, []differ.Input, error) {
+		inputNames, inputDirs, err := config.Inputs()
+		if err != nil {
+			return differ.RuleSet{}, nil, err
+		}
+		ruleSet, err := config.LoadRuleSet()
+		if err != nil {
+			return differ.RuleSet{}, nil, err
+		}
+		if err := checkRuleInputs(ruleSet, inputNames); err != nil {
+			return differ.RuleSet{}, nil, err
+		}
+		inputs := make([]differ.Input, len(inputDirs))
+		for i, inputDir := range inputDirs {
+			objects, err := differ.ReadStateFromDirectory(inputDir)
+			if err != nil {
+				return differ.RuleSet{}, nil, err
+			}
+			inputs[i] = differ.Input{Name: inputNames[i], Objects: objects}
+		}
+		return ruleSet, inputs, nil
+	}
+
+	// Stdout carries the protocol, anything else printed goes to stderr.
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+	return lsp.NewServer(load).Serve(os.Stdin, stdout)
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "schema" {
+		// Print the JSON Schema of rule files, e.g. for the yaml-language-server.
+		if err := differ.WriteRuleFileSchema(os.Stdout); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "krm-function" {
+		// Run as a KRM function in kustomize or kpt, taking the rules from
+		// the functionConfig.
+		if err := differ.RunKrmFunction(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "import-kustomize" {
+		// Print the JSON 6902 patches of a kustomization as patch rules.
+		if len(os.Args) != 3 {
+			fmt.Fprintln(os.Stderr, "usage: yaml-patch import-kustomize <kustomization file or directory>")
+			os.Exit(1)
+		}
+		rules, err := differ.ImportKustomization(os.Args[2])
+		if err == nil {
+			err = differ.WriteImportedRules(os.Stdout, rules)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "lsp" {
+		if err := serveLSP(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	var config = &Config{}
 	config.RegisterFlags(flag.CommandLine)
 
 	flag.Parse()
 
-	if len(config.InputDir) != len(config.OutputDir) {
+	if config.ListRules {
+		ruleSet, err := config.LoadRuleSet()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		err = differ.WriteRuleList(os.Stdout, &ruleSet)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if config.ComparesInputs() && len(config.InputDir) != 2 {
+		fmt.Fprintln(os.Stderr, "--diff, --detect-renames, --suggest, --baseline and --write-baseline require exactly two input-dir")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if config.Matrix && len(config.InputDir) < 2 {
+		fmt.Fprintln(os.Stderr, "--matrix requires at least two input-dir")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if len(config.InputDir) != len(config.OutputDir) && !(config.OutputIsOptional() && len(config.OutputDir) == 0) {
 		fmt.Fprintln(os.Stderr, "--input-dir and --output-dir must have the same number of elements")
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	if len(config.InputDir) == 0 || len(config.OutputDir) == 0 {
+	if len(config.InputDir) == 0 || (len(config.OutputDir) == 0 && !config.OutputIsOptional()) {
 		fmt.Println("input-dir and output-dir are required")
 		flag.Usage()
 		os.Exit(1)
 	}
 
+	inputNames, inputDirs, err := config.Inputs()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
 	ruleSet, err := config.LoadRuleSet()
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	var debugInfo = differ.NewDebugInfo(ruleSet)
+	err = checkRuleInputs(ruleSet, inputNames)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
-	for i, inputDir := range config.InputDir {
-		outputDir := config.OutputDir[i]
+	var debugInfo = differ.NewDebugInfo(ruleSet)
+	var inputs = make([]differ.Input, len(inputDirs))
 
+	for i, inputDir := range inputDirs {
 		objects, err := differ.ReadStateFromDirectory(inputDir)
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
 
-		debugInfo.AddInitialObjects(objects)
+		debugInfo.ForInput(inputNames[i]).AddInitialObjects(objects)
+		inputs[i] = differ.Input{Name: inputNames[i], Objects: objects}
+	}
 
-		objects, err = differ.ApplyRuleSet(objects, ruleSet, debugInfo)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+	results, err := differ.ApplyRuleSetToInputs(inputs, ruleSet, debugInfo)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	for i, objects := range results {
+		if len(config.OutputDir) == 0 {
+			break
 		}
 
-		err = differ.WriteStateToDirectory(objects, outputDir, config.OutputTemplate)
+		err = differ.WriteStateToDirectory(objects, config.OutputDir[i], config.OutputTemplate)
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
@@ -104,4 +330,74 @@ func main() {
 	if config.PrintTodo {
 		debugInfo.Print()
 	}
+
+	if config.Matrix {
+		rows, err := differ.BuildMatrix(results, differ.DiffOptions{ShowSecrets: config.ShowSecrets})
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		err = differ.WriteMatrix(os.Stdout, inputNames, rows)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	if !config.ComparesInputs() {
+		return
+	}
+
+	diffs, err := differ.DiffObjectSets(results[0], results[1], differ.DiffOptions{ShowSecrets: config.ShowSecrets})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if config.Diff {
+		differ.WriteDiff(os.Stdout, inputNames[0], inputNames[1], diffs)
+	}
+
+	if config.DetectRenames {
+		suggestions, err := differ.DetectRenames(diffs, config.RenameMinScore)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		differ.WriteRenameSuggestions(os.Stdout, suggestions)
+	}
+
+	if config.Suggest {
+		err = differ.WriteRuleSuggestions(os.Stdout, differ.SuggestRules(diffs))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	if config.WriteBaseline != "" {
+		err = differ.WriteBaseline(config.WriteBaseline, differ.NewBaseline(diffs))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	if config.Baseline != "" {
+		baseline, err := differ.LoadBaseline(config.Baseline)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		newDiffs, stale := baseline.Compare(diffs)
+		for _, entry := range stale {
+			fmt.Printf("baseline entry no longer occurs and can be pruned: %s\n", entry)
+		}
+		if len(newDiffs) > 0 {
+			fmt.Println("differences not in the baseline:")
+			differ.WriteDiff(os.Stdout, inputNames[0], inputNames[1], newDiffs)
+			os.Exit(1)
+		}
+	}
 }
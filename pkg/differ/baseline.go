@@ -0,0 +1,163 @@
+package differ
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Baseline records differences which have been accepted for now, so that only
+// new differences have to be looked at.
+type Baseline struct {
+	Differences []BaselineEntry `yaml:"differences"`
+}
+
+// BaselineEntry identifies a difference by the object and path it occurs at
+// and a hash of the value on each side. A side without a value has an empty
+// hash. Objects only present on one side are recorded with an empty path.
+// Values of Secret data are not hashed, see secretValue.
+type BaselineEntry struct {
+	Object string `yaml:"object"`
+	Path   string `yaml:"path,omitempty"`
+	Left   string `yaml:"left,omitempty"`
+	Right  string `yaml:"right,omitempty"`
+}
+
+func (e BaselineEntry) String() string {
+	if e.Path == "" {
+		return e.Object
+	}
+	return e.Object + " " + e.Path
+}
+
+// NewBaseline records all differences.
+func NewBaseline(diffs []ObjectDiff) Baseline {
+	var baseline Baseline
+	for _, od := range diffs {
+		for _, entry := range baselineEntries(od) {
+			baseline.Differences = append(baseline.Differences, entry.entry)
+		}
+	}
+	return baseline
+}
+
+type baselineEntry struct {
+	entry BaselineEntry
+	// difference is nil for the entries of objects only present on one side.
+	difference *Difference
+}
+
+func baselineEntries(od ObjectDiff) []baselineEntry {
+	object := od.Identity.String()
+	switch {
+	case od.Right == nil:
+		return []baselineEntry{{entry: BaselineEntry{Object: object, Left: hashValue("present")}}}
+	case od.Left == nil:
+		return []baselineEntry{{entry: BaselineEntry{Object: object, Right: hashValue("present")}}}
+	}
+
+	var result []baselineEntry
+	for i, d := range od.Differences {
+		entry := BaselineEntry{Object: object, Path: d.FullPath()}
+		hash := hashValue
+		if od.Identity.Kind == "Secret" && isSecretDataPath(d.Path) {
+			hash = func(interface{}) string { return secretValue }
+		}
+		if d.Type != DifferenceOnlyRight {
+			entry.Left = hash(d.Left)
+		}
+		if d.Type != DifferenceOnlyLeft {
+			entry.Right = hash(d.Right)
+		}
+		result = append(result, baselineEntry{entry: entry, difference: &od.Differences[i]})
+	}
+	return result
+}
+
+// secretValue stands for a value of Secret data in baseline entries. Even a
+// hash of it would let it be recovered by a dictionary attack once the
+// baseline is committed, and would depend on whether it was redacted. A
+// changed Secret value therefore matches its entry as long as the type of the
+// difference stays the same.
+const secretValue = "secret"
+
+func hashValue(value interface{}) string {
+	sum := sha256.Sum256([]byte(FormatValue(value)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Compare returns the differences not recorded in the baseline, and the
+// baseline entries which no longer occur and can be pruned.
+func (b Baseline) Compare(diffs []ObjectDiff) (newDiffs []ObjectDiff, stale []BaselineEntry) {
+	var known = map[BaselineEntry]bool{}
+	for _, entry := range b.Differences {
+		known[entry] = true
+	}
+
+	var seen = map[BaselineEntry]bool{}
+	for _, od := range diffs {
+		filtered := od
+		filtered.Differences = nil
+		isNew := false
+		for _, e := range baselineEntries(od) {
+			seen[e.entry] = true
+			if known[e.entry] {
+				continue
+			}
+			isNew = true
+			if e.difference != nil {
+				filtered.Differences = append(filtered.Differences, *e.difference)
+			}
+		}
+		if isNew {
+			newDiffs = append(newDiffs, filtered)
+		}
+	}
+
+	for _, entry := range b.Differences {
+		if !seen[entry] {
+			stale = append(stale, entry)
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool { return stale[i].String() < stale[j].String() })
+	return newDiffs, stale
+}
+
+func LoadBaseline(path string) (Baseline, error) {
+	var baseline Baseline
+	f, err := os.Open(path)
+	if err != nil {
+		return baseline, fmt.Errorf("failed to open baseline file: %w", err)
+	}
+	defer f.Close()
+	err = yaml.NewDecoder(f).Decode(&baseline)
+	if err != nil && err != io.EOF {
+		return baseline, fmt.Errorf("failed to decode baseline file: %w", err)
+	}
+	return baseline, nil
+}
+
+func WriteBaseline(path string, baseline Baseline) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create baseline file: %w", err)
+	}
+	enc := yaml.NewEncoder(f)
+	if err := enc.Encode(baseline); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to encode baseline file: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to encode baseline file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write baseline file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package differ
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBaseline(t *testing.T) {
+	left := []*YamlObject{
+		newDeploymentWithLabels("querier", map[string]string{"name": "querier", "zone": "a"}),
+		newDeploymentWithLabels("ruler", map[string]string{"name": "ruler"}),
+	}
+	right := []*YamlObject{
+		newDeploymentWithLabels("querier", map[string]string{"name": "querier"}),
+	}
+
+	diffs, err := DiffObjectSets(left, right, DiffOptions{})
+	require.NoError(t, err)
+
+	baseline := NewBaseline(diffs)
+	require.Len(t, baseline.Differences, 4)
+
+	path := filepath.Join(t.TempDir(), "baseline.yaml")
+	require.NoError(t, WriteBaseline(path, baseline))
+	baseline, err = LoadBaseline(path)
+	require.NoError(t, err)
+
+	t.Run("write errors are reported", func(t *testing.T) {
+		if _, err := os.Stat("/dev/full"); err != nil {
+			t.Skip("/dev/full is not available")
+		}
+		require.Error(t, WriteBaseline("/dev/full", baseline))
+	})
+
+	t.Run("differences recorded in the baseline are accepted", func(t *testing.T) {
+		newDiffs, stale := baseline.Compare(diffs)
+		require.Empty(t, newDiffs)
+		require.Empty(t, stale)
+	})
+
+	t.Run("new differences and stale entries are reported", func(t *testing.T) {
+		left := []*YamlObject{
+			newDeploymentWithLabels("querier", map[string]string{"name": "querier", "zone": "b"}),
+		}
+		diffs, err := DiffObjectSets(left, right, DiffOptions{})
+		require.NoError(t, err)
+
+		newDiffs, stale := baseline.Compare(diffs)
+		require.Len(t, newDiffs, 1)
+		require.Equal(t, "Deployment/default/querier", newDiffs[0].Identity.String())
+		require.Equal(t, []string{
+			"/metadata/labels/zone",
+			"/spec/selector/matchLabels/zone",
+			"/spec/template/metadata/labels/zone",
+		}, differencePaths(newDiffs[0].Differences))
+
+		// The old zone label and the ruler are gone.
+		require.Len(t, stale, 4)
+		require.Equal(t, "Deployment/default/querier /metadata/labels/zone", stale[0].String())
+		require.Equal(t, "Deployment/default/ruler", stale[3].String())
+	})
+	t.Run("secret values are not hashed", func(t *testing.T) {
+		encode := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+		newSecret := func(password string) *YamlObject {
+			secret := newConfigMap("credentials", map[string]interface{}{"password": encode(password)})
+			secret.Object["kind"] = "Secret"
+			return secret
+		}
+		left, right := []*YamlObject{newSecret("hunter2")}, []*YamlObject{newSecret("hunter3")}
+
+		shown, err := DiffObjectSets(left, right, DiffOptions{ShowSecrets: true})
+		require.NoError(t, err)
+		baseline := NewBaseline(shown)
+		require.Equal(t, []BaselineEntry{{Object: "Secret/default/credentials", Path: "/data/password", Left: secretValue, Right: secretValue}}, baseline.Differences)
+
+		redacted, err := DiffObjectSets(left, right, DiffOptions{})
+		require.NoError(t, err)
+		newDiffs, stale := baseline.Compare(redacted)
+		require.Empty(t, newDiffs, "baselines don't depend on -show-secrets")
+		require.Empty(t, stale)
+	})
+}
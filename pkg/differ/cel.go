@@ -0,0 +1,94 @@
+package differ
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+)
+
+// celEnv declares the variables available to the when expressions of rules:
+// object is the object the rule is applied to.
+var celEnv = func() *cel.Env {
+	env, err := cel.NewEnv(cel.Declarations(decls.NewVar("object", decls.Dyn)))
+	if err != nil {
+		panic(err)
+	}
+	return env
+}()
+
+// celPrograms caches compiled expressions by source, since rules are
+// evaluated once per object.
+var celPrograms sync.Map
+
+// CompileCEL compiles a when expression of a rule.
+func CompileCEL(expr string) (cel.Program, error) {
+	if program, ok := celPrograms.Load(expr); ok {
+		return program.(cel.Program), nil
+	}
+	ast, issues := celEnv.Compile(expr)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("invalid CEL expression %q: %v", expr, issues.Err())
+	}
+	program, err := celEnv.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CEL expression %q: %v", expr, err)
+	}
+	celPrograms.Store(expr, program)
+	return program, nil
+}
+
+// whenDescription describes a when expression as a condition of a rule.
+func whenDescription(expr string) []string {
+	if expr == "" {
+		return nil
+	}
+	return []string{"when: " + expr}
+}
+
+// matchesWhen evaluates a when expression against an object, recording a
+// match as the given condition. An expression selecting a field or list item
+// the object doesn't have doesn't match, like a failing match step. Use has()
+// to test for optional fields. Other evaluation errors, e.g. comparing values
+// of different types, are mistakes in the expression and are returned.
+func matchesWhen(expr string, obj *YamlObject, condition int, debug *RuleDebugInfo) (bool, error) {
+	if expr == "" {
+		return true, nil
+	}
+	program, err := CompileCEL(expr)
+	if err != nil {
+		return false, err
+	}
+	doc, err := obj.Document()
+	if err != nil {
+		return false, err
+	}
+	// Whole numbers are converted to ints, so that they compare with integer
+	// literals such as the 1 in object.spec.replicas > 1.
+	result, _, err := program.Eval(map[string]interface{}{"object": wholeNumbersToInts(doc)})
+	if err != nil {
+		if isMissingFieldError(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("CEL expression %q failed: %v", expr, err)
+	}
+	matches, ok := result.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("CEL expression %q returned %v instead of a bool", expr, result.Value())
+	}
+	if matches {
+		debug.RecordConditionMatch(condition, obj)
+	}
+	return matches, nil
+}
+
+// isMissingFieldError reports whether a CEL evaluation error is due to the
+// object not having a selected map key or list index.
+func isMissingFieldError(err error) bool {
+	msg := err.Error()
+	return strings.HasPrefix(msg, "no such key") ||
+		strings.HasPrefix(msg, "index out of bounds") ||
+		strings.Contains(msg, "out of range in list")
+}
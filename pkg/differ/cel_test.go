@@ -0,0 +1,127 @@
+package differ
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestWhenConditions(t *testing.T) {
+	newStatefulSet := func(name string, replicas int, image string) *YamlObject {
+		obj := NewYamlObject(name + ".yaml")
+		require.NoError(t, DecodeYamlObject(strings.NewReader(`
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: `+name+`
+spec:
+  replicas: `+strconv.Itoa(replicas)+`
+  template:
+    spec:
+      containers:
+      - image: `+image+`
+`), obj))
+		return obj
+	}
+
+	apply := func(t *testing.T, rules string, objects []*YamlObject) ([]*YamlObject, *DebugInfo) {
+		var ruleSet RuleSet
+		require.NoError(t, yaml.Unmarshal([]byte(rules), &ruleSet))
+		ruleSet.Desugar()
+		debugInfo := NewDebugInfo(ruleSet)
+		debugInfo.AddInitialObjects(objects)
+		results, err := ApplyRuleSet(objects, ruleSet, debugInfo)
+		require.NoError(t, err)
+		return results, debugInfo
+	}
+
+	objects := func() []*YamlObject {
+		return []*YamlObject{
+			newStatefulSet("ingester", 3, "grafana/mimir:2.0.0"),
+			newStatefulSet("store-gateway", 1, "grafana/mimir:2.0.0"),
+			newStatefulSet("memcached", 3, "memcached:1.6"),
+		}
+	}
+
+	t.Run("when expressions select objects", func(t *testing.T) {
+		results, debugInfo := apply(t, `
+ignore_rules:
+- name: replicated mimir
+  match:
+  - {op: test, path: /kind, value: StatefulSet}
+  when: object.spec.replicas > 1 && object.spec.template.spec.containers[0].image.startsWith("grafana/mimir")
+`, objects())
+
+		require.NoError(t, debugInfo.ValidateAllRulesWereEffective())
+		var names []string
+		for _, obj := range results {
+			names = append(names, ObjectIdentityForObject(obj).Name)
+		}
+		require.Equal(t, []string{"store-gateway", "memcached"}, names)
+	})
+
+	t.Run("missing fields don't match", func(t *testing.T) {
+		results, debugInfo := apply(t, `
+patch_rules:
+- name: annotated
+  when: object.metadata.annotations["a"] == "b"
+  steps:
+  - {op: remove, path: /spec/replicas}
+`, objects())
+
+		require.Len(t, results, 3)
+		err := debugInfo.ValidateAllRulesWereEffective()
+		require.Error(t, err, "a condition which never holds is ineffective")
+		require.Contains(t, err.Error(), `when: object.metadata.annotations["a"] == "b" did not hold`)
+	})
+
+	t.Run("missing list items don't match", func(t *testing.T) {
+		results, debugInfo := apply(t, `
+patch_rules:
+- name: sidecar
+  when: object.spec.template.spec.containers[1].name == "sidecar"
+  steps:
+  - {op: remove, path: /spec/replicas}
+`, objects())
+
+		require.Len(t, results, 3)
+		require.Error(t, debugInfo.ValidateAllRulesWereEffective())
+	})
+
+	t.Run("other evaluation errors are reported", func(t *testing.T) {
+		var ruleSet RuleSet
+		require.NoError(t, yaml.Unmarshal([]byte(`
+patch_rules:
+- name: no such overload
+  when: object.metadata.name > 1
+`), &ruleSet))
+		_, err := ApplyRuleSet(objects(), ruleSet, nil)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `CEL expression "object.metadata.name > 1" failed: no such overload`)
+	})
+
+	t.Run("expressions must return a bool", func(t *testing.T) {
+		var ruleSet RuleSet
+		require.NoError(t, yaml.Unmarshal([]byte(`
+patch_rules:
+- name: not a bool
+  when: object.kind
+`), &ruleSet))
+		_, err := ApplyRuleSet(objects(), ruleSet, nil)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "instead of a bool")
+	})
+
+	t.Run("invalid expressions are reported when loading", func(t *testing.T) {
+		_, _, err := decodeRuleFile("rules.yaml", []byte(`
+patch_rules:
+- name: invalid
+  when: object.kind ==
+`), nil)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "rules.yaml:4:9: invalid CEL expression")
+	})
+}
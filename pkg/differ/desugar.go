@@ -2,22 +2,48 @@ package differ
 
 import (
 	"fmt"
-
-	"github.com/mitchellh/copystructure"
+	"sort"
+	"strings"
 )
 
 func Desugar(rule Json6902PatchRule) []Json6902PatchRule {
 	if rule.RenameObject != nil {
-		rule.Match = append(rule.Match, Json6902Operation{
-			Op:    "test",
-			Path:  "/metadata/name",
-			Value: rule.RenameObject.From,
-		})
-		rule.Steps = append(rule.Steps, Json6902Operation{
-			Op:    "replace",
-			Path:  "/metadata/name",
-			Value: rule.RenameObject.To,
-		})
+		if rule.RenameObject.Kind != "" {
+			rule.Match = append(rule.Match, Json6902Operation{
+				Op:    "test",
+				Path:  "/kind",
+				Value: rule.RenameObject.Kind,
+			})
+		}
+		if rule.RenameObject.Regex {
+			rule.Match = append(rule.Match, Json6902Operation{
+				Op:    "test_regex",
+				Path:  "/metadata/name",
+				Value: rule.RenameObject.From,
+			})
+			rule.Steps = append(rule.Steps, Json6902Operation{
+				Op:   "substitute",
+				Path: "/metadata/name",
+				Value: map[string]interface{}{
+					"pattern":     rule.RenameObject.From,
+					"replacement": rule.RenameObject.To,
+				},
+			})
+		} else {
+			rule.Match = append(rule.Match, Json6902Operation{
+				Op:    "test",
+				Path:  "/metadata/name",
+				Value: rule.RenameObject.From,
+			})
+			rule.Steps = append(rule.Steps, Json6902Operation{
+				Op:    "replace",
+				Path:  "/metadata/name",
+				Value: rule.RenameObject.To,
+			})
+		}
+		if rule.RenameObject.UpdateReferences {
+			rule.References = append([]ReferencePath{}, DefaultReferencePaths...)
+		}
 		if rule.Name == "" {
 			rule.Name = fmt.Sprintf("Rename %s to %s", rule.RenameObject.From, rule.RenameObject.To)
 		}
@@ -55,41 +81,132 @@ func Desugar(rule Json6902PatchRule) []Json6902PatchRule {
 		rule.RenameField = nil
 	}
 
-	finalRules := []Json6902PatchRule{rule}
+	if rule.SetField != nil {
+		// The add operation in the match ensures that the parent of the field
+		// exists.
+		set := Json6902Operation{
+			Op:    "add",
+			Path:  rule.SetField.Path,
+			Value: rule.SetField.Value,
+		}
+		rule.Match = append(rule.Match, set)
+		rule.Steps = append(rule.Steps, set)
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("Set %s to %v", rule.SetField.Path, rule.SetField.Value)
+		}
+		rule.SetField = nil
+	}
 
-	for path, values := range rule.Matchers {
-		finalRules = addMatchRulesToAll(finalRules, path, values)
+	if rule.CopyField != nil {
+		rule.Match = append(rule.Match, Json6902Operation{
+			Op:   "remove",
+			Path: rule.CopyField.From,
+		})
+		rule.Steps = append(rule.Steps, Json6902Operation{
+			Op:   "copy",
+			Path: rule.CopyField.To,
+			From: rule.CopyField.From,
+		})
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("Copy %s to %s", rule.CopyField.From, rule.CopyField.To)
+		}
+		rule.CopyField = nil
 	}
 
-	rule.Matchers = nil
+	if len(rule.RemoveFields) > 0 {
+		for _, field := range rule.RemoveFields {
+			rule.Match = append(rule.Match, Json6902Operation{
+				Op:   "remove",
+				Path: field,
+			})
+			rule.Steps = append(rule.Steps, Json6902Operation{
+				Op:   "remove",
+				Path: field,
+			})
+		}
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("Remove %s", strings.Join(rule.RemoveFields, ", "))
+		}
+		rule.RemoveFields = nil
+	}
 
-	return finalRules
-}
+	if rule.AddIfMissing != nil {
+		// The field must be absent, and its parent must exist.
+		add := Json6902Operation{
+			Op:    "add",
+			Path:  rule.AddIfMissing.Path,
+			Value: rule.AddIfMissing.Value,
+		}
+		rule.Match = append(rule.Match, Json6902Operation{
+			Op:   "test_absent",
+			Path: rule.AddIfMissing.Path,
+		}, add)
+		rule.Steps = append(rule.Steps, add)
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("Default %s to %v", rule.AddIfMissing.Path, rule.AddIfMissing.Value)
+		}
+		rule.AddIfMissing = nil
+	}
 
-func addMatchRulesToAll(rules []Json6902PatchRule, path string, values []interface{}) []Json6902PatchRule {
-	output := []Json6902PatchRule{}
+	if rule.RemoveIfEquals != nil {
+		rule.Match = append(rule.Match, Json6902Operation{
+			Op:    "test",
+			Path:  rule.RemoveIfEquals.Path,
+			Value: rule.RemoveIfEquals.Value,
+		})
+		rule.Steps = append(rule.Steps, Json6902Operation{
+			Op:   "remove",
+			Path: rule.RemoveIfEquals.Path,
+		})
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("Remove %s equal to %v", rule.RemoveIfEquals.Path, rule.RemoveIfEquals.Value)
+		}
+		rule.RemoveIfEquals = nil
+	}
 
-	for _, jpr := range rules {
-		output = append(output, addMatchRules(jpr, path, values)...)
+	// A matcher with a single value is a match step. A matcher with several
+	// values is evaluated as one condition, which holds if any value matches.
+	paths := make([]string, 0, len(rule.Matchers))
+	for path := range rule.Matchers {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		values := rule.Matchers[path]
+		if len(values) == 1 {
+			rule.Match = append(rule.Match, matcherOperation(path, values[0]))
+			continue
+		}
+		m := matcher{Path: path}
+		for _, value := range values {
+			m.Alternatives = append(m.Alternatives, matcherOperation(path, value))
+		}
+		rule.matchers = append(rule.matchers, m)
 	}
+	rule.Matchers = nil
 
-	return output
+	return []Json6902PatchRule{rule}
 }
 
-func addMatchRules(rule Json6902PatchRule, path string, values []interface{}) []Json6902PatchRule {
-	var output = []Json6902PatchRule{}
-	for _, value := range values {
-		copy, err := copystructure.Copy(rule)
-		if err != nil {
-			panic(err)
-		}
-		newRule := copy.(Json6902PatchRule)
-		newRule.Match = append(newRule.Match, Json6902Operation{
-			Op:    "test",
-			Path:  path,
-			Value: value,
-		})
-		output = append(output, newRule)
+// matcherOperation converts a matcher value into a match operation. Values
+// are tested for equality, unless they are a map with a single match
+// operation as key, e.g. {test_glob: "*-zone-a"}.
+func matcherOperation(path string, value interface{}) Json6902Operation {
+	var op, opValue interface{}
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		if len(v) == 1 {
+			for op, opValue = range v {
+			}
+		}
+	case map[string]interface{}:
+		if len(v) == 1 {
+			for op, opValue = range v {
+			}
+		}
+	}
+	if name, ok := op.(string); ok && IsMatchOp(name) {
+		return Json6902Operation{Op: name, Path: path, Value: opValue}
 	}
-	return output
+	return Json6902Operation{Op: "test", Path: path, Value: value}
 }
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
 )
 
 func TestDesugaring(t *testing.T) {
@@ -73,6 +74,49 @@ func TestDesugaring(t *testing.T) {
 		require.Equal(t, "querier", rule.Steps[0].Value)
 	})
 
+	t.Run("rename_object with a regex desugars to a substitute operation", func(t *testing.T) {
+		rule := Json6902PatchRule{
+			RenameObject: &RenameRule{
+				From:  "^mimir-(.*)$",
+				To:    "$1",
+				Regex: true,
+				Kind:  "StatefulSet",
+			},
+		}
+		rules := Desugar(rule)
+		require.Len(t, rules, 1)
+		rule = rules[0]
+
+		// We test the kind, then use a test_regex operation to find objects matching the pattern
+		require.Len(t, rule.Match, 2)
+		require.Equal(t, "test", rule.Match[0].Op)
+		require.Equal(t, "/kind", rule.Match[0].Path)
+		require.Equal(t, "test_regex", rule.Match[1].Op)
+		require.Equal(t, "/metadata/name", rule.Match[1].Path)
+
+		// Then we use a substitute operation to rename the object
+		require.Len(t, rule.Steps, 1)
+		require.Equal(t, "substitute", rule.Steps[0].Op)
+
+		object := newDeploymentWithLabels("mimir-ingester-zone-a", nil)
+		object.Object["kind"] = "StatefulSet"
+		debugInfo := NewDebugInfo(RuleSet{PatchRules: rules}).NewRuleDebugInfo(0, rule)
+		result, err := rule.MapObject(object, debugInfo)
+		require.NoError(t, err)
+		name, err := result.Get("/metadata/name")
+		require.NoError(t, err)
+		require.Equal(t, "ingester-zone-a", name)
+		require.NoError(t, debugInfo.ValidateAllStepsWereEffective())
+
+		// A pattern matching nothing is reported as ineffective
+		object = newDeploymentWithLabels("ingester", nil)
+		object.Object["kind"] = "StatefulSet"
+		debugInfo = NewDebugInfo(RuleSet{PatchRules: rules}).NewRuleDebugInfo(0, rule)
+		_, err = rule.MapObject(object, debugInfo)
+		require.NoError(t, err)
+		require.Error(t, debugInfo.ValidateAllStepsWereEffective())
+	})
+
 	t.Run("complex desugaring appends to match and patch steps", func(t *testing.T) {
 		rule := Json6902PatchRule{
 			Name: "Mixed desugaring operations",
@@ -131,37 +175,112 @@ func TestDesugaring(t *testing.T) {
 		require.Equal(t, "Deployment", rule.Match[1].Value)
 	})
 
-	t.Run("matches on arrays are converted to multiple rules", func(t *testing.T) {
+	t.Run("matches on arrays are converted to one condition", func(t *testing.T) {
 		rule := Json6902PatchRule{
 			Name:        "Remove a field from two specific kinds",
 			RemoveField: "/spec/replicas",
 			Matchers: map[string][]interface{}{
-				"/kind": {"Deployment", "StatefulSet"},
+				"/kind":          {"Deployment", "StatefulSet"},
+				"/metadata/name": {"ingester", "querier"},
 			},
 		}
 		rules := Desugar(rule)
-		require.Len(t, rules, 2)
-		{
-			rule = rules[0]
-
-			// We use a test operation to find objects with the correct kind
-			require.Len(t, rule.Match, 2)
-			require.Equal(t, "remove", rule.Match[0].Op)
-			require.Equal(t, "/spec/replicas", rule.Match[0].Path)
-			require.Equal(t, "test", rule.Match[1].Op)
-			require.Equal(t, "/kind", rule.Match[1].Path)
-			require.Equal(t, "Deployment", rule.Match[1].Value)
+		require.Len(t, rules, 1)
+		rule = rules[0]
+
+		// The values are alternatives of a single condition per matcher
+		// rather than match operations.
+		require.Len(t, rule.Match, 1)
+		require.Equal(t, []string{
+			"/kind: one of [Deployment, StatefulSet]",
+			"/metadata/name: one of [ingester, querier]",
+		}, rule.Describe().Conditions)
+		require.Empty(t, rule.Describe().Alternatives)
+
+		rule.MatchEachValue = true
+		require.Equal(t, []string{
+			"/kind: Deployment",
+			"/kind: StatefulSet",
+			"/kind: one of [Deployment, StatefulSet]",
+			"/metadata/name: ingester",
+			"/metadata/name: querier",
+			"/metadata/name: one of [ingester, querier]",
+		}, rule.Describe().Conditions)
+		require.Equal(t, map[int]bool{0: true, 1: true, 3: true, 4: true}, rule.Describe().Alternatives)
+	})
+
+	t.Run("field shorthands desugar to a match and a step", func(t *testing.T) {
+		rules := Desugar(Json6902PatchRule{
+			SetField:       &FieldValue{Path: "/spec/replicas", Value: 3},
+			CopyField:      &FieldCopy{From: "/metadata/labels/name", To: "/metadata/labels/app"},
+			RemoveFields:   []string{"/metadata/labels/chart", "/metadata/labels/heritage"},
+			AddIfMissing:   &FieldValue{Path: "/spec/revisionHistoryLimit", Value: 10},
+			RemoveIfEquals: &FieldValue{Path: "/spec/progressDeadlineSeconds", Value: 600},
+		})
+		require.Len(t, rules, 1)
+		rule := rules[0]
+
+		require.Equal(t, "Set /spec/replicas to 3", rule.Name)
+		require.Equal(t, Json6902Patch{
+			{Op: "add", Path: "/spec/replicas", Value: 3},
+			{Op: "remove", Path: "/metadata/labels/name"},
+			{Op: "remove", Path: "/metadata/labels/chart"},
+			{Op: "remove", Path: "/metadata/labels/heritage"},
+			{Op: "test_absent", Path: "/spec/revisionHistoryLimit"},
+			{Op: "add", Path: "/spec/revisionHistoryLimit", Value: 10},
+			{Op: "test", Path: "/spec/progressDeadlineSeconds", Value: 600},
+		}, rule.Match)
+		require.Equal(t, Json6902Patch{
+			{Op: "add", Path: "/spec/replicas", Value: 3},
+			{Op: "copy", Path: "/metadata/labels/app", From: "/metadata/labels/name"},
+			{Op: "remove", Path: "/metadata/labels/chart"},
+			{Op: "remove", Path: "/metadata/labels/heritage"},
+			{Op: "add", Path: "/spec/revisionHistoryLimit", Value: 10},
+			{Op: "remove", Path: "/spec/progressDeadlineSeconds"},
+		}, rule.Steps)
+	})
+
+	t.Run("field shorthands only apply to objects they change", func(t *testing.T) {
+		var ruleSet RuleSet
+		require.NoError(t, yaml.Unmarshal([]byte(`
+patch_rules:
+- add_if_missing: {path: /data/mode, value: default}
+- remove_if_equals: {path: /data/level, value: info}
+- set_field: {path: /data/owner, value: mimir}
+`), &ruleSet))
+		ruleSet.Desugar()
+		objects := []*YamlObject{
+			newConfigMap("implicit", map[string]interface{}{"level": "info"}),
+			newConfigMap("explicit", map[string]interface{}{"mode": "default", "level": "debug"}),
 		}
-		{
-			rule = rules[1]
-
-			// We use a test operation to find objects with the correct kind
-			require.Len(t, rule.Match, 2)
-			require.Equal(t, "remove", rule.Match[0].Op)
-			require.Equal(t, "/spec/replicas", rule.Match[0].Path)
-			require.Equal(t, "test", rule.Match[1].Op)
-			require.Equal(t, "/kind", rule.Match[1].Path)
-			require.Equal(t, "StatefulSet", rule.Match[1].Value)
+		debugInfo := NewDebugInfo(ruleSet)
+		debugInfo.AddInitialObjects(objects)
+		results, err := ApplyRuleSet(objects, ruleSet, debugInfo)
+		require.NoError(t, err)
+		require.NoError(t, debugInfo.ValidateAllRulesWereEffective())
+
+		for _, tc := range []struct {
+			obj   *YamlObject
+			path  string
+			value interface{}
+		}{
+			{results[0], "/data/mode", "default"},
+			{results[0], "/data/owner", "mimir"},
+			{results[1], "/data/level", "debug"},
+			{results[1], "/data/owner", "mimir"},
+		} {
+			value, err := tc.obj.Get(tc.path)
+			require.NoError(t, err)
+			require.Equal(t, tc.value, value)
 		}
+		_, err = results[0].Get("/data/level")
+		require.Error(t, err, "the level equal to info was removed")
+
+		// Defaults which are already explicit everywhere are reported.
+		debugInfo = NewDebugInfo(ruleSet)
+		debugInfo.AddInitialObjects(results)
+		_, err = ApplyRuleSet(results, ruleSet, debugInfo)
+		require.NoError(t, err)
+		require.Error(t, debugInfo.ValidateAllRulesWereEffective())
 	})
 }
@@ -0,0 +1,536 @@
+package differ
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ObjectIdentity identifies an object independently of the file it was read
+// from. Objects on both sides of a diff are paired by their identity.
+type ObjectIdentity struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+func (o ObjectIdentity) String() string {
+	if o.Namespace == "" {
+		return o.Kind + "/" + o.Name
+	}
+	return o.Kind + "/" + o.Namespace + "/" + o.Name
+}
+
+// ObjectIdentityForObject returns the identity of an object. Objects without a
+// kind and a name (e.g. generated config files) are identified by the base
+// name of their source file instead.
+func ObjectIdentityForObject(obj *YamlObject) ObjectIdentity {
+	var id ObjectIdentity
+	id.Kind, _ = stringAt(obj, "/kind")
+	id.Namespace, _ = stringAt(obj, "/metadata/namespace")
+	id.Name, _ = stringAt(obj, "/metadata/name")
+	if id.Kind == "" && id.Name == "" {
+		id.Name = filepath.Base(obj.ResourceKey.Source)
+	}
+	return id
+}
+
+func stringAt(obj *YamlObject, path string) (string, bool) {
+	value, err := obj.Get(path)
+	if err != nil {
+		return "", false
+	}
+	s, ok := value.(string)
+	return s, ok
+}
+
+type DifferenceType int
+
+const (
+	DifferenceChanged DifferenceType = iota
+	DifferenceOnlyLeft
+	DifferenceOnlyRight
+)
+
+// Difference is a single path-level difference between two paired objects.
+type Difference struct {
+	// Path is a JSON pointer into the object.
+	Path string
+	// Embedded is a JSON pointer into the YAML or JSON document embedded in
+	// the string at Path. It is empty unless the difference is inside such a
+	// document.
+	Embedded string
+	Type     DifferenceType
+	Left     interface{}
+	Right    interface{}
+	// Redacted is true if Left and Right are placeholders for the values of
+	// Secret data, see DiffOptions. Only the type of the difference is known
+	// then, not whether values on different paths are equal.
+	Redacted bool
+}
+
+// DiffOptions control how objects are compared.
+type DiffOptions struct {
+	// ShowSecrets keeps the values of Secret data in the differences, decoded
+	// from base64. By default they are only decoded to be compared, and
+	// replaced by a placeholder, since diffs often end up in CI logs.
+	ShowSecrets bool
+}
+
+// FullPath returns Path, suffixed by "#" and the Embedded pointer if the
+// difference is inside an embedded document.
+func (d Difference) FullPath() string {
+	if d.Embedded == "" {
+		return d.Path
+	}
+	return d.Path + "#" + d.Embedded
+}
+
+// ObjectDiff holds the differences between two objects with the same
+// identity. Left or Right is nil if the object only exists on one side.
+type ObjectDiff struct {
+	Identity    ObjectIdentity
+	Left        *YamlObject
+	Right       *YamlObject
+	Differences []Difference
+}
+
+// DiffObjectSets pairs the objects of both sides by identity and computes the
+// differences between each pair. The result is sorted by identity.
+func DiffObjectSets(left, right []*YamlObject, opts DiffOptions) ([]ObjectDiff, error) {
+	var byIdentity = map[ObjectIdentity]*ObjectDiff{}
+	var get = func(obj *YamlObject) *ObjectDiff {
+		id := ObjectIdentityForObject(obj)
+		if _, ok := byIdentity[id]; !ok {
+			byIdentity[id] = &ObjectDiff{Identity: id}
+		}
+		return byIdentity[id]
+	}
+	for _, obj := range left {
+		get(obj).Left = obj
+	}
+	for _, obj := range right {
+		get(obj).Right = obj
+	}
+
+	result := make([]ObjectDiff, 0, len(byIdentity))
+	for _, od := range byIdentity {
+		if od.Left != nil && od.Right != nil {
+			differences, err := DiffObjects(od.Left, od.Right, opts)
+			if err != nil {
+				return nil, fmt.Errorf("failed to diff %s: %w", od.Identity, err)
+			}
+			od.Differences = differences
+		}
+		result = append(result, *od)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Identity.String() < result[j].Identity.String()
+	})
+	return result, nil
+}
+
+// DiffObjects computes the path-level differences between two objects. String
+// values in the data of ConfigMaps and Secrets that parse as YAML or JSON on
+// both sides are compared structurally. The values of Secret data are
+// redacted unless opts.ShowSecrets is set.
+func DiffObjects(left, right *YamlObject, opts DiffOptions) ([]Difference, error) {
+	leftDoc, err := left.Document()
+	if err != nil {
+		return nil, err
+	}
+	rightDoc, err := right.Document()
+	if err != nil {
+		return nil, err
+	}
+
+	differences := diffValues("", leftDoc, rightDoc)
+
+	kind := ObjectIdentityForObject(left).Kind
+	if kind != "ConfigMap" && kind != "Secret" {
+		return differences, nil
+	}
+
+	result := make([]Difference, 0, len(differences))
+	for _, d := range differences {
+		result = append(result, diffEmbedded(kind, d)...)
+	}
+	if kind == "Secret" && !opts.ShowSecrets {
+		for i := range result {
+			if isSecretDataPath(result[i].Path) {
+				result[i] = redactDifference(result[i])
+			}
+		}
+	}
+	return result, nil
+}
+
+func diffValues(path string, left, right interface{}) []Difference {
+	switch l := left.(type) {
+	case map[string]interface{}:
+		r, ok := right.(map[string]interface{})
+		if !ok {
+			break
+		}
+		var differences []Difference
+		for _, k := range sortedKeys(l, r) {
+			childPath := path + "/" + escapePointerToken(k)
+			lv, lok := l[k]
+			rv, rok := r[k]
+			switch {
+			case lok && !rok:
+				differences = append(differences, Difference{Path: childPath, Type: DifferenceOnlyLeft, Left: lv})
+			case !lok && rok:
+				differences = append(differences, Difference{Path: childPath, Type: DifferenceOnlyRight, Right: rv})
+			default:
+				differences = append(differences, diffValues(childPath, lv, rv)...)
+			}
+		}
+		return differences
+	case []interface{}:
+		r, ok := right.([]interface{})
+		if !ok {
+			break
+		}
+		var differences []Difference
+		for i := 0; i < len(l) || i < len(r); i++ {
+			childPath := path + "/" + strconv.Itoa(i)
+			switch {
+			case i >= len(r):
+				differences = append(differences, Difference{Path: childPath, Type: DifferenceOnlyLeft, Left: l[i]})
+			case i >= len(l):
+				differences = append(differences, Difference{Path: childPath, Type: DifferenceOnlyRight, Right: r[i]})
+			default:
+				differences = append(differences, diffValues(childPath, l[i], r[i])...)
+			}
+		}
+		return differences
+	}
+
+	if reflect.DeepEqual(left, right) {
+		return nil
+	}
+	return []Difference{{Path: path, Type: DifferenceChanged, Left: left, Right: right}}
+}
+
+func sortedKeys(maps ...map[string]interface{}) []string {
+	var seen = map[string]bool{}
+	var keys []string
+	for _, m := range maps {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// diffEmbedded replaces a changed data value by the structural differences of
+// the documents embedded in it, if both sides contain one.
+func diffEmbedded(kind string, d Difference) []Difference {
+	if d.Type != DifferenceChanged || !isDataPath(kind, d.Path) {
+		return []Difference{d}
+	}
+	leftText, lok := d.Left.(string)
+	rightText, rok := d.Right.(string)
+	if !lok || !rok {
+		return []Difference{d}
+	}
+	if kind == "Secret" && strings.HasPrefix(d.Path, "/data/") {
+		leftText = decodeBase64OrRaw(leftText)
+		rightText = decodeBase64OrRaw(rightText)
+		d.Left, d.Right = leftText, rightText
+	}
+
+	leftDoc, lok := parseEmbeddedDocument(leftText)
+	rightDoc, rok := parseEmbeddedDocument(rightText)
+	if !lok || !rok {
+		return []Difference{d}
+	}
+
+	embedded := diffValues("", leftDoc, rightDoc)
+	if len(embedded) == 0 || embedded[0].Path == "" {
+		// Either only formatting differs or the documents have different
+		// types at the root, report the text change as is.
+		return []Difference{d}
+	}
+	for i := range embedded {
+		embedded[i].Embedded = embedded[i].Path
+		embedded[i].Path = d.Path
+	}
+	return embedded
+}
+
+func isDataPath(kind, path string) bool {
+	if strings.Count(path, "/") != 2 {
+		return false
+	}
+	if strings.HasPrefix(path, "/data/") {
+		return true
+	}
+	return kind == "Secret" && strings.HasPrefix(path, "/stringData/")
+}
+
+// isSecretDataPath returns true if a path of a Secret is or is inside its
+// data or stringData.
+func isSecretDataPath(path string) bool {
+	for _, field := range []string{"/data", "/stringData"} {
+		if path == field || strings.HasPrefix(path, field+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// redactedValue replaces the values of Secret data in differences.
+const redactedValue = "<redacted>"
+
+// redactDifference replaces the values of a difference by redactedValue. No
+// hash of the values is kept: short passwords and tokens of a known format
+// could be recovered from it.
+func redactDifference(d Difference) Difference {
+	if d.Left != nil {
+		d.Left = redactedValue
+	}
+	if d.Right != nil {
+		d.Right = redactedValue
+	}
+	d.Redacted = true
+	return d
+}
+
+// redactionKey keys the HMAC of redactValue. It is random, so that redacted
+// values can only be compared within one run.
+var redactionKey = func() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}()
+
+// redactValue replaces a value of Secret data by an HMAC of it, for the
+// matrix, which has to tell which inputs agree. Equal values have the same
+// placeholder within one run.
+func redactValue(value interface{}) string {
+	mac := hmac.New(sha256.New, redactionKey)
+	mac.Write([]byte(FormatValue(value)))
+	return fmt.Sprintf("<redacted %x>", mac.Sum(nil)[:6])
+}
+
+func decodeBase64OrRaw(text string) string {
+	decoded, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		return text
+	}
+	return string(decoded)
+}
+
+// parseEmbeddedDocument parses text as YAML (and therefore JSON). Only
+// documents with a map or list at the root are considered structured, since
+// any plain text parses as a YAML scalar.
+func parseEmbeddedDocument(text string) (interface{}, bool) {
+	var raw interface{}
+	if err := yaml.Unmarshal([]byte(text), &raw); err != nil {
+		return nil, false
+	}
+	switch raw.(type) {
+	case map[interface{}]interface{}, []interface{}:
+	default:
+		return nil, false
+	}
+
+	jsonBuf, err := json.Marshal(jsonCompatible(raw))
+	if err != nil {
+		return nil, false
+	}
+	var doc interface{}
+	if err := json.Unmarshal(jsonBuf, &doc); err != nil {
+		return nil, false
+	}
+	return doc, true
+}
+
+// jsonCompatible converts the map[interface{}]interface{} values produced by
+// yaml.v2 into map[string]interface{} so that they can be encoded as JSON.
+func jsonCompatible(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			result[fmt.Sprint(k)] = jsonCompatible(item)
+		}
+		return result
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			result[k] = jsonCompatible(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = jsonCompatible(item)
+		}
+		return result
+	default:
+		return v
+	}
+}
+
+func escapePointerToken(token string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
+
+// FormatValue renders a value on a single line. Scalars are printed as is,
+// maps and lists as compact JSON.
+func FormatValue(value interface{}) string {
+	switch v := value.(type) {
+	case map[string]interface{}, []interface{}:
+		buf, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(buf)
+	case string:
+		return strconv.Quote(v)
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// WriteDiff renders the differences in a human readable form. Multi-line
+// strings that could not be compared structurally are shown as a line diff.
+func WriteDiff(w io.Writer, leftName, rightName string, diffs []ObjectDiff) {
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", leftName, rightName)
+	for _, od := range diffs {
+		switch {
+		case od.Right == nil:
+			fmt.Fprintf(w, "\nonly in %s: %s (%s)\n", leftName, od.Identity, od.Left.ResourceKey)
+			continue
+		case od.Left == nil:
+			fmt.Fprintf(w, "\nonly in %s: %s (%s)\n", rightName, od.Identity, od.Right.ResourceKey)
+			continue
+		case len(od.Differences) == 0:
+			continue
+		}
+
+		fmt.Fprintf(w, "\n%s\n", od.Identity)
+		for _, d := range od.Differences {
+			writeDifference(w, d)
+		}
+	}
+}
+
+func writeDifference(w io.Writer, d Difference) {
+	if d.Redacted {
+		switch d.Type {
+		case DifferenceOnlyLeft:
+			fmt.Fprintf(w, "  - %s: %s\n", d.FullPath(), redactedValue)
+		case DifferenceOnlyRight:
+			fmt.Fprintf(w, "  + %s: %s\n", d.FullPath(), redactedValue)
+		default:
+			fmt.Fprintf(w, "  ~ %s: %s changed\n", d.FullPath(), redactedValue)
+		}
+		return
+	}
+	switch d.Type {
+	case DifferenceOnlyLeft:
+		fmt.Fprintf(w, "  - %s: %s\n", d.FullPath(), FormatValue(d.Left))
+	case DifferenceOnlyRight:
+		fmt.Fprintf(w, "  + %s: %s\n", d.FullPath(), FormatValue(d.Right))
+	default:
+		leftText, lok := d.Left.(string)
+		rightText, rok := d.Right.(string)
+		if lok && rok && (strings.Contains(leftText, "\n") || strings.Contains(rightText, "\n")) {
+			fmt.Fprintf(w, "  ~ %s:\n", d.FullPath())
+			for _, line := range DiffLines(leftText, rightText, 2) {
+				fmt.Fprintf(w, "      %s\n", line)
+			}
+			return
+		}
+		fmt.Fprintf(w, "  ~ %s: %s => %s\n", d.FullPath(), FormatValue(d.Left), FormatValue(d.Right))
+	}
+}
+
+// DiffLines computes a line-level diff between two texts. Removed lines are
+// prefixed with "- ", added lines with "+ " and unchanged lines with "  ".
+// Only unchanged lines within context lines of a change are kept, skipped
+// lines are replaced by "...".
+func DiffLines(left, right string, context int) []string {
+	l := strings.Split(strings.TrimSuffix(left, "\n"), "\n")
+	r := strings.Split(strings.TrimSuffix(right, "\n"), "\n")
+
+	// lcs[i][j] is the length of the longest common subsequence of l[i:] and r[j:].
+	lcs := make([][]int, len(l)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(r)+1)
+	}
+	for i := len(l) - 1; i >= 0; i-- {
+		for j := len(r) - 1; j >= 0; j-- {
+			if l[i] == r[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var lines []string
+	var changed []bool
+	i, j := 0, 0
+	for i < len(l) || j < len(r) {
+		switch {
+		case i < len(l) && j < len(r) && l[i] == r[j]:
+			lines = append(lines, "  "+l[i])
+			changed = append(changed, false)
+			i++
+			j++
+		case j < len(r) && (i == len(l) || lcs[i][j+1] > lcs[i+1][j]):
+			lines = append(lines, "+ "+r[j])
+			changed = append(changed, true)
+			j++
+		default:
+			lines = append(lines, "- "+l[i])
+			changed = append(changed, true)
+			i++
+		}
+	}
+
+	var result []string
+	skipped := false
+	for k := range lines {
+		keep := false
+		for c := k - context; c <= k+context; c++ {
+			if c >= 0 && c < len(changed) && changed[c] {
+				keep = true
+				break
+			}
+		}
+		if keep {
+			result = append(result, lines[k])
+			skipped = false
+		} else if !skipped {
+			result = append(result, "...")
+			skipped = true
+		}
+	}
+	return result
+}
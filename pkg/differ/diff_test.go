@@ -0,0 +1,154 @@
+package differ
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newConfigMap(name string, data map[string]interface{}) *YamlObject {
+	return &YamlObject{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]interface{}{
+				"name":      name,
+				"namespace": "default",
+			},
+			"data": data,
+		},
+		ResourceKey: ResourceKey{Source: name + ".yaml"},
+	}
+}
+
+func TestDiffObjectSets(t *testing.T) {
+	t.Run("objects are paired by identity", func(t *testing.T) {
+		left := []*YamlObject{
+			newDeploymentWithLabels("querier", map[string]string{"name": "querier"}),
+			newDeploymentWithLabels("ingester", map[string]string{"name": "ingester"}),
+		}
+		right := []*YamlObject{
+			newDeploymentWithLabels("querier", map[string]string{"name": "querier", "zone": "a"}),
+		}
+
+		diffs, err := DiffObjectSets(left, right, DiffOptions{})
+		require.NoError(t, err)
+		require.Len(t, diffs, 2)
+
+		require.Equal(t, "Deployment/default/ingester", diffs[0].Identity.String())
+		require.NotNil(t, diffs[0].Left)
+		require.Nil(t, diffs[0].Right)
+
+		require.Equal(t, "Deployment/default/querier", diffs[1].Identity.String())
+		require.Equal(t, []string{
+			"/metadata/labels/zone",
+			"/spec/selector/matchLabels/zone",
+			"/spec/template/metadata/labels/zone",
+		}, differencePaths(diffs[1].Differences))
+		require.Equal(t, DifferenceOnlyRight, diffs[1].Differences[0].Type)
+		require.Equal(t, "a", diffs[1].Differences[0].Right)
+	})
+}
+
+func TestDiffObjects(t *testing.T) {
+	t.Run("yaml embedded in a config map is compared structurally", func(t *testing.T) {
+		left := newConfigMap("mimir-config", map[string]interface{}{
+			"mimir.yaml": "ingester:\n  ring:\n    replication_factor: 3\ntarget: all\n",
+		})
+		right := newConfigMap("mimir-config", map[string]interface{}{
+			"mimir.yaml": "target: all\ningester:\n  ring:\n    replication_factor: 1\n",
+		})
+
+		differences, err := DiffObjects(left, right, DiffOptions{})
+		require.NoError(t, err)
+		require.Len(t, differences, 1)
+		require.Equal(t, "/data/mimir.yaml", differences[0].Path)
+		require.Equal(t, "/ingester/ring/replication_factor", differences[0].Embedded)
+		require.Equal(t, "/data/mimir.yaml#/ingester/ring/replication_factor", differences[0].FullPath())
+		require.Equal(t, float64(3), differences[0].Left)
+		require.Equal(t, float64(1), differences[0].Right)
+	})
+
+	t.Run("json embedded in a secret is decoded and compared structurally", func(t *testing.T) {
+		encode := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+		left := newConfigMap("overrides", map[string]interface{}{
+			"overrides.json": encode(`{"tenant-1": {"ingestion_rate": 10}}`),
+		})
+		left.Object["kind"] = "Secret"
+		right := newConfigMap("overrides", map[string]interface{}{
+			"overrides.json": encode(`{"tenant-1": {"ingestion_rate": 20}}`),
+		})
+		right.Object["kind"] = "Secret"
+
+		differences, err := DiffObjects(left, right, DiffOptions{})
+		require.NoError(t, err)
+		require.Len(t, differences, 1)
+		require.Equal(t, "/data/overrides.json#/tenant-1/ingestion_rate", differences[0].FullPath())
+		require.True(t, differences[0].Redacted)
+		require.Equal(t, DifferenceChanged, differences[0].Type)
+		require.Equal(t, redactedValue, differences[0].Left)
+		require.Equal(t, redactedValue, differences[0].Right)
+
+		differences, err = DiffObjects(left, right, DiffOptions{ShowSecrets: true})
+		require.NoError(t, err)
+		require.Equal(t, float64(10), differences[0].Left)
+		require.Equal(t, float64(20), differences[0].Right)
+	})
+
+	t.Run("secret values are redacted unless shown", func(t *testing.T) {
+		encode := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+		newSecret := func(data map[string]interface{}) *YamlObject {
+			secret := newConfigMap("credentials", data)
+			secret.Object["kind"] = "Secret"
+			return secret
+		}
+		left := newSecret(map[string]interface{}{"password": encode("hunter2"), "user": encode("admin")})
+		right := newSecret(map[string]interface{}{"password": encode("hunter3")})
+
+		differences, err := DiffObjects(left, right, DiffOptions{})
+		require.NoError(t, err)
+		var out bytes.Buffer
+		WriteDiff(&out, "left", "right", []ObjectDiff{{Identity: ObjectIdentityForObject(left), Left: left, Right: right, Differences: differences}})
+		require.NotContains(t, out.String(), "hunter")
+		require.NotContains(t, out.String(), "admin")
+		require.NotContains(t, out.String(), encode("hunter2"))
+		require.Contains(t, out.String(), "~ /data/password: <redacted> changed")
+		require.Contains(t, out.String(), "- /data/user: <redacted>")
+		require.NotContains(t, out.String(), "sha256", "no hash of the values is printed")
+
+		differences, err = DiffObjects(left, right, DiffOptions{ShowSecrets: true})
+		require.NoError(t, err)
+		require.Equal(t, "hunter2", differences[0].Left)
+		require.Equal(t, "hunter3", differences[0].Right)
+	})
+
+	t.Run("plain text falls back to a text difference", func(t *testing.T) {
+		left := newConfigMap("scripts", map[string]interface{}{
+			"run.sh": "#!/bin/sh\nset -e\nexec mimir\n",
+		})
+		right := newConfigMap("scripts", map[string]interface{}{
+			"run.sh": "#!/bin/sh\nset -eu\nexec mimir\n",
+		})
+
+		differences, err := DiffObjects(left, right, DiffOptions{})
+		require.NoError(t, err)
+		require.Len(t, differences, 1)
+		require.Equal(t, "/data/run.sh", differences[0].FullPath())
+		require.Equal(t, DifferenceChanged, differences[0].Type)
+	})
+}
+
+func TestDiffLines(t *testing.T) {
+	lines := DiffLines("a\nb\nc\nd\ne\nf\n", "a\nb\nc\nD\ne\nf\n", 1)
+	require.Equal(t, []string{"...", "  c", "- d", "+ D", "  e", "..."}, lines)
+}
+
+func differencePaths(differences []Difference) []string {
+	paths := []string{}
+	for _, d := range differences {
+		paths = append(paths, d.FullPath())
+	}
+	return paths
+}
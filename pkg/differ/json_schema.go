@@ -0,0 +1,275 @@
+package differ
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+)
+
+// schemaDescriptions describe the fields of rule files in the JSON Schema,
+// by type and YAML key. Each of them also appears in the field reference of
+// the README, TestSchemaDescriptionsInReadme keeps them in sync.
+var schemaDescriptions = map[string]string{
+	"RuleSet":                 "A yaml-patch rule file.",
+	"RuleSet.include":         "Other rule files or globs loaded before this file, relative to it.",
+	"RuleSet.vars":            "Vars which can be referred to as ${name} in any string of this file and the files it includes.",
+	"RuleSet.ignore_rules":    "Rules removing the objects they match from the output. They run in the phase ignore_rules.",
+	"RuleSet.patch_rules":     "Rules modifying the objects they match. They run in the phase patch_rules.",
+	"RuleSet.rules":           "An ordered list mixing ignore and patch rules. They run in the phase rules.",
+	"RuleSet.phases":          "Named, ordered lists of rules. Phases with the same name in several files are merged.",
+	"RuleSet.phase_order":     "Phases which run first, in this order.",
+	"RuleSet.reference_paths": "Fields referring to other objects by name, updated by rename_object with update_references.",
+
+	"Rule":              "An entry of an ordered list of rules, holding exactly one ignore, patch, script or KRM function rule.",
+	"Rule.ignore":       "An ignore rule.",
+	"Rule.patch":        "A patch rule.",
+	"Rule.script":       "A script rule.",
+	"Rule.krm_function": "A KRM function rule.",
+
+	"Phase":       "A named, ordered list of rules.",
+	"Phase.name":  "The name of the phase, referred to by phase_order.",
+	"Phase.rules": "The rules of the phase, each holding exactly one ignore, patch, script or KRM function rule.",
+
+	"IgnoreRule":       "Removes every object it matches from the output.",
+	"IgnoreRule.name":  "Documentation only, used in the program output to communicate issues.",
+	"IgnoreRule.match": "Operations which must all succeed on an object for the rule to match it. An empty match matches every object.",
+	"IgnoreRule.todo":  "Marks a rule as a difference still to be resolved, printed with -print-todo.",
+
+	"ScriptRule":         "Transforms every object it matches with a Starlark program.",
+	"ScriptRule.name":    "Documentation only, used in the program output to communicate issues.",
+	"ScriptRule.match":   "Operations which must all succeed on an object for the rule to match it. An empty match matches every object.",
+	"ScriptRule.program": "A Starlark program defining transform(object), which returns the modified object, or None to remove it from the output.",
+	"ScriptRule.todo":    "Marks a rule as a difference still to be resolved, printed with -print-todo.",
+
+	"KrmFunctionRule":         "Runs a KRM function, an executable reading a ResourceList of all objects on stdin and writing the transformed ResourceList on stdout.",
+	"KrmFunctionRule.name":    "Documentation only, used in the program output to communicate issues.",
+	"KrmFunctionRule.exec":    "The executable of the function, looked up in PATH. Paths containing a slash are relative to the rule file.",
+	"KrmFunctionRule.args":    "The arguments of the executable.",
+	"KrmFunctionRule.config":  "The functionConfig of the ResourceList.",
+	"KrmFunctionRule.timeout": "How long the function may run, e.g. 30s, before it is killed and the rule fails. One minute if absent.",
+	"KrmFunctionRule.todo":    "Marks a rule as a difference still to be resolved, printed with -print-todo.",
+
+	"Json6902PatchRule":                  "Modifies every object it matches. Keys starting with / are matchers, testing the value at that path against a list of alternatives.",
+	"Json6902PatchRule.name":             "Documentation only, used in the program output to communicate issues. Generated from the shorthand if absent.",
+	"Json6902PatchRule.match":            "Operations which must all succeed on an object for the rule to match it. An empty match matches every object.",
+	"Json6902PatchRule.steps":            "Operations applied to every object the rule matches.",
+	"Json6902PatchRule.todo":             "Marks a rule as a difference still to be resolved, printed with -print-todo.",
+	"Json6902PatchRule.remove_field":     "Shorthand removing the field at this path from every object having it.",
+	"Json6902PatchRule.rename_field":     "Shorthand moving the value of a field to another field.",
+	"Json6902PatchRule.rename_object":    "Shorthand renaming objects by /metadata/name.",
+	"Json6902PatchRule.set_field":        "Shorthand adding or replacing the value of a field in every object having its parent.",
+	"Json6902PatchRule.copy_field":       "Shorthand copying the value of a field to another field.",
+	"Json6902PatchRule.remove_fields":    "Shorthand removing the fields at these paths from every object having all of them.",
+	"Json6902PatchRule.add_if_missing":   "Shorthand setting the value of a field in every object not having it, e.g. to make an implicit default explicit.",
+	"Json6902PatchRule.remove_if_equals": "Shorthand removing a field from every object where it equals the value, e.g. to remove an explicit default.",
+	"Json6902PatchRule.match_each_value": "Requires every value of the matchers to match at least one object, not only the rule as a whole.",
+
+	"matcher": "Matches objects whose value at this path equals one of the values. A value can also be a map with a match operation as its only key, e.g. {test_glob: \"*-zone-a\"}.",
+
+	"Target":                    "Selects objects like the target of kustomize patches. Empty fields match every object.",
+	"Target.group":              "A regular expression the API group of the object must match entirely.",
+	"Target.version":            "A regular expression the API version of the object, without its group, must match entirely.",
+	"Target.kind":               "A regular expression the kind of the object must match entirely.",
+	"Target.name":               "A regular expression the name of the object must match entirely.",
+	"Target.namespace":          "A regular expression the namespace of the object must match entirely.",
+	"Target.labelSelector":      "A label selector, e.g. app=querier,tier!=cache, on the labels of the object.",
+	"Target.annotationSelector": "A label selector on the annotations of the object.",
+
+	"when":               "A CEL expression evaluated against the object as object, which must also hold for the rule to match it.",
+	"inputs":             "The names of the inputs the rule applies to, given as -input-dir name=dir. All inputs if absent.",
+	"target":             "Selects the objects of the rule like the target of kustomize patches, in addition to match.",
+	"other_side":         "absent to only match objects without a paired object in the other input, present to only match objects with one.",
+	"other_side_equals":  "Only matches objects whose value at this path equals the value in the paired object.",
+	"other_side_missing": "Only matches objects whose paired object is absent or has no value at this path.",
+
+	"RenameRule":                   "Renames an object or a field.",
+	"RenameRule.from":              "The current name, or JSON pointer for rename_field.",
+	"RenameRule.to":                "The new name, or JSON pointer for rename_field.",
+	"RenameRule.regex":             "rename_object only: from is a regular expression and to a template which can refer to its capture groups.",
+	"RenameRule.kind":              "rename_object only: only rename objects of this kind.",
+	"RenameRule.update_references": "rename_object only: also rename references to the object in other objects of the same input.",
+
+	"Json6902Operation":       "An RFC 6902 JSON patch operation, or one of the additional match operations.",
+	"Json6902Operation.op":    "The operation.",
+	"Json6902Operation.path":  "The JSON pointer the operation applies to. May use * wildcards and [key=value] predicates.",
+	"Json6902Operation.from":  "The JSON pointer to move or copy from.",
+	"Json6902Operation.value": "The value to add, replace or test with.",
+
+	"FieldValue":       "A field and a value.",
+	"FieldValue.path":  "The JSON pointer of the field.",
+	"FieldValue.value": "The value.",
+
+	"FieldCopy":      "A field to copy and its destination.",
+	"FieldCopy.from": "The JSON pointer of the field to copy.",
+	"FieldCopy.to":   "The JSON pointer of the copy.",
+
+	"ReferencePath":                 "A field of an object referring to another object by name.",
+	"ReferencePath.kind":            "The kind of the object referred to.",
+	"ReferencePath.referrer_kind":   "The kind of the object holding the reference, any kind if absent.",
+	"ReferencePath.path":            "The path of the reference.",
+	"ReferencePath.namespace_field": "The field next to the reference holding the namespace of the object referred to, e.g. namespace in the subjects of RoleBindings. The namespace of the referrer if absent.",
+}
+
+// RuleFileSchema returns a JSON Schema of rule files, generated from RuleSet
+// and the types of its fields. It fails if a field has a type JSON Schema
+// has no equivalent for.
+func RuleFileSchema() (map[string]interface{}, error) {
+	g := &schemaGenerator{definitions: map[string]interface{}{}}
+	root, err := g.structSchema(reflect.TypeOf(RuleSet{}))
+	if err != nil {
+		return nil, err
+	}
+	root["properties"].(map[string]interface{})["vars"] = map[string]interface{}{
+		"type":                 "object",
+		"description":          schemaDescriptions["RuleSet.vars"],
+		"additionalProperties": map[string]interface{}{"type": "string"},
+	}
+
+	root["$schema"] = "http://json-schema.org/draft-07/schema#"
+	root["title"] = "yaml-patch rule file"
+	root["definitions"] = g.definitions
+	return root, nil
+}
+
+// WriteRuleFileSchema prints the JSON Schema of rule files.
+func WriteRuleFileSchema(w io.Writer) error {
+	schema, err := RuleFileSchema()
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(schema)
+}
+
+type schemaGenerator struct {
+	definitions map[string]interface{}
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) (map[string]interface{}, error) {
+	properties := map[string]interface{}{}
+	for _, f := range yamlStructFields(t) {
+		property, err := g.typeSchema(f.field.Type)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %v", t.Name(), f.name, err)
+		}
+		description, ok := schemaDescriptions[t.Name()+"."+f.name]
+		if !ok {
+			description = schemaDescriptions[f.name]
+		}
+		if description != "" {
+			property["description"] = description
+		}
+		properties[f.name] = property
+	}
+
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if description := schemaDescriptions[t.Name()]; description != "" {
+		schema["description"] = description
+	}
+
+	switch t {
+	case reflect.TypeOf(Json6902PatchRule{}):
+		schema["patternProperties"] = map[string]interface{}{
+			"^/": map[string]interface{}{
+				"type":        "array",
+				"description": schemaDescriptions["matcher"],
+			},
+		}
+	case reflect.TypeOf(Json6902Operation{}):
+		properties["op"].(map[string]interface{})["enum"] = knownOps()
+		schema["required"] = []string{"op", "path"}
+		var needFrom, needValue []string
+		for _, op := range knownOps() {
+			from, value := opRequires(op)
+			if from {
+				needFrom = append(needFrom, op)
+			}
+			if value {
+				needValue = append(needValue, op)
+			}
+		}
+		schema["allOf"] = []interface{}{
+			requireIfOp(needFrom, "from"),
+			requireIfOp(needValue, "value"),
+		}
+	case reflect.TypeOf(Rule{}):
+		schema["oneOf"] = []interface{}{
+			map[string]interface{}{"required": []string{"ignore"}},
+			map[string]interface{}{"required": []string{"patch"}},
+			map[string]interface{}{"required": []string{"script"}},
+			map[string]interface{}{"required": []string{"krm_function"}},
+		}
+	}
+	return schema, nil
+}
+
+func (g *schemaGenerator) typeSchema(t reflect.Type) (map[string]interface{}, error) {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return g.typeSchema(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}, nil
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}, nil
+	case reflect.Slice:
+		items, err := g.typeSchema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"type": "array", "items": items}, nil
+	case reflect.Map:
+		values, err := g.typeSchema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"type": "object", "additionalProperties": values}, nil
+	case reflect.Interface:
+		return map[string]interface{}{}, nil
+	case reflect.Struct:
+		if _, ok := g.definitions[t.Name()]; !ok {
+			// Reserve the name first, types may refer to themselves.
+			g.definitions[t.Name()] = nil
+			schema, err := g.structSchema(t)
+			if err != nil {
+				return nil, err
+			}
+			g.definitions[t.Name()] = schema
+		}
+		return map[string]interface{}{"$ref": "#/definitions/" + t.Name()}, nil
+	}
+	return nil, fmt.Errorf("no schema for %s", t)
+}
+
+func requireIfOp(ops []string, field string) map[string]interface{} {
+	return map[string]interface{}{
+		"if": map[string]interface{}{
+			"properties": map[string]interface{}{"op": map[string]interface{}{"enum": ops}},
+		},
+		"then": map[string]interface{}{"required": []string{field}},
+	}
+}
+
+// knownOps returns every op IsKnownOp accepts, sorted.
+func knownOps() []string {
+	ops := []string{"substitute"}
+	for op := range patchOps {
+		ops = append(ops, op)
+	}
+	for op := range matchOps {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	return ops
+}
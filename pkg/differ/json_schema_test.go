@@ -0,0 +1,81 @@
+package differ
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuleFileSchema(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteRuleFileSchema(&buf))
+
+	var schema struct {
+		Properties  map[string]map[string]interface{} `json:"properties"`
+		Definitions map[string]struct {
+			Properties        map[string]map[string]interface{} `json:"properties"`
+			PatternProperties map[string]interface{}            `json:"patternProperties"`
+			OneOf             []struct {
+				Required []string `json:"required"`
+			} `json:"oneOf"`
+		} `json:"definitions"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &schema))
+
+	require.Contains(t, schema.Properties, "vars")
+	require.Contains(t, schema.Properties, "patch_rules")
+	require.Contains(t, schema.Definitions["Json6902PatchRule"].Properties, "other_side")
+	require.Contains(t, schema.Definitions["Json6902PatchRule"].PatternProperties, "^/")
+	require.Contains(t, schema.Definitions["Json6902Operation"].Properties["op"]["enum"], "test_glob")
+
+	t.Run("rules hold exactly one kind of rule", func(t *testing.T) {
+		var kinds []string
+		for _, variant := range schema.Definitions["Rule"].OneOf {
+			kinds = append(kinds, variant.Required...)
+		}
+		require.Equal(t, []string{"ignore", "patch", "script", "krm_function"}, kinds)
+		require.Len(t, schema.Definitions["Rule"].Properties, len(kinds), "every kind of rule is a variant")
+	})
+
+	t.Run("every field has a description", func(t *testing.T) {
+		for name, property := range schema.Properties {
+			require.NotEmpty(t, property["description"], name)
+		}
+		for definition, d := range schema.Definitions {
+			for name, property := range d.Properties {
+				require.NotEmpty(t, property["description"], definition+"."+name)
+			}
+		}
+	})
+	t.Run("numbers have a schema, unsupported types are errors", func(t *testing.T) {
+		type Scaling struct {
+			Replicas int     `yaml:"replicas"`
+			Ratio    float64 `yaml:"ratio"`
+		}
+		g := &schemaGenerator{definitions: map[string]interface{}{}}
+		schema, err := g.typeSchema(reflect.TypeOf(Scaling{}))
+		require.NoError(t, err)
+		require.Equal(t, map[string]interface{}{"$ref": "#/definitions/Scaling"}, schema)
+		properties := g.definitions["Scaling"].(map[string]interface{})["properties"].(map[string]interface{})
+		require.Equal(t, "integer", properties["replicas"].(map[string]interface{})["type"])
+		require.Equal(t, "number", properties["ratio"].(map[string]interface{})["type"])
+
+		_, err = g.typeSchema(reflect.TypeOf(map[string]func(){}))
+		require.EqualError(t, err, "no schema for func()")
+	})
+}
+
+func TestSchemaDescriptionsInReadme(t *testing.T) {
+	readme, err := os.ReadFile("../../README.md")
+	require.NoError(t, err)
+	// The README wraps lines, compare the words only.
+	words := strings.Join(strings.Fields(string(readme)), " ")
+	for key, description := range schemaDescriptions {
+		require.Contains(t, words, description, "%s is not described in the field reference of the README", key)
+	}
+}
@@ -0,0 +1,295 @@
+package differ
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Annotations yaml-patch sets on the items of the ResourceList passed to KRM
+// functions. idAnnotation pairs the items a function returns with the objects
+// it was given, like kustomize and kpt do, and pathAnnotation is the file an
+// object was read from.
+const (
+	idAnnotation   = "config.k8s.io/id"
+	pathAnnotation = "config.kubernetes.io/path"
+)
+
+// defaultKrmFunctionTimeout is how long a KRM function may run unless its rule
+// sets a timeout.
+const defaultKrmFunctionTimeout = time.Minute
+
+// KrmFunctionRule runs a KRM function, an executable reading a ResourceList of
+// all objects on stdin and writing the transformed ResourceList on stdout.
+// See https://github.com/kubernetes-sigs/kustomize/blob/master/cmd/config/docs/api-conventions/functions-spec.md.
+type KrmFunctionRule struct {
+	Name string `yaml:"name,omitempty"`
+
+	// Exec is the executable of the function, looked up in PATH. Paths
+	// containing a slash are relative to the rule file.
+	Exec string   `yaml:"exec"`
+	Args []string `yaml:"args,omitempty"`
+
+	// Config is the functionConfig of the ResourceList.
+	Config map[string]interface{} `yaml:"config,omitempty"`
+
+	// Timeout is how long the function may run, e.g. "30s". The function is
+	// killed and the rule fails after that. One minute if empty.
+	Timeout string `yaml:"timeout,omitempty"`
+
+	Todo bool `yaml:"todo,omitempty"`
+
+	// Inputs restricts the rule to the named inputs.
+	Inputs []string `yaml:"inputs,omitempty"`
+
+	// Source is the rule file the rule was loaded from, and Line the line of
+	// the rule in it.
+	Source string `yaml:"-"`
+	Line   int    `yaml:"-"`
+}
+
+func (k KrmFunctionRule) Describe() ObjectRuleDescription {
+	name := k.Name
+	if name == "" {
+		name = "krm_function " + k.Exec
+	}
+	return ObjectRuleDescription{
+		Name: name,
+		Todo: k.Todo,
+		// The function is a single step, which must change, remove or add at
+		// least one object.
+		PatchRules: Json6902Patch{{Op: "krm_function " + k.Exec}},
+		Inputs:     k.Inputs,
+		Source:     k.Source,
+		Line:       k.Line,
+	}
+}
+
+func (k KrmFunctionRule) MapObject(obj *YamlObject, debug *RuleDebugInfo) (*YamlObject, error) {
+	result, err := k.MapObjectSet([]*YamlObject{obj}, debug)
+	if err != nil || len(result) == 0 {
+		return nil, err
+	}
+	return result[0], nil
+}
+
+// MapObjectSet passes all objects to the function at once. Objects the
+// function returns are paired with the objects it was given by the
+// config.k8s.io/id annotation, objects without it were added by the function.
+// The annotations the objects had before are restored afterwards.
+func (k KrmFunctionRule) MapObjectSet(objects []*YamlObject, debug *RuleDebugInfo) ([]*YamlObject, error) {
+	items := make([]map[string]interface{}, len(objects))
+	saved := make([]savedAnnotations, len(objects))
+	for i, obj := range objects {
+		doc, err := obj.Document()
+		if err != nil {
+			return nil, err
+		}
+		item, ok := wholeNumbersToInts(doc).(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s is not a map", ResourceKeyForObject(obj))
+		}
+		saved[i] = setAnnotations(item, map[string]string{
+			idAnnotation:   strconv.Itoa(i),
+			pathAnnotation: obj.ResourceKey.Source,
+		})
+		items[i] = item
+	}
+
+	output, err := k.run(items)
+	if err != nil {
+		return nil, fmt.Errorf("krm_function %q: %v", k.Describe().Name, err)
+	}
+
+	result := []*YamlObject{}
+	returned := map[int]bool{}
+	for _, item := range output {
+		id, hasID := annotation(item, idAnnotation)
+		path, _ := annotation(item, pathAnnotation)
+		i, err := strconv.Atoi(id)
+		if !hasID || err != nil || i < 0 || i >= len(objects) || returned[i] {
+			removeAnnotations(item, idAnnotation, pathAnnotation)
+			obj := &YamlObject{Object: item, ResourceKey: ResourceKey{Source: path}}
+			if obj.ResourceKey.Source == "" {
+				obj.ResourceKey.Source = ObjectIdentityForObject(obj).String()
+			}
+			debug.RecordAdd(obj)
+			result = append(result, obj)
+			continue
+		}
+
+		returned[i] = true
+		saved[i].restore(item)
+		original := objects[i].DeepCopy()
+		objects[i].Object = item
+		debug.RecordIncrementalPatch(0, original, objects[i])
+		result = append(result, objects[i])
+	}
+	for i, obj := range objects {
+		if !returned[i] {
+			debug.RecordIgnore(obj)
+		}
+	}
+	return result, nil
+}
+
+// run executes the function on a ResourceList of the items and returns the
+// items of the ResourceList it writes.
+func (k KrmFunctionRule) run(items []map[string]interface{}) ([]map[string]interface{}, error) {
+	input := NewResourceList(items)
+	input.FunctionConfig = k.Config
+	var stdin bytes.Buffer
+	if err := EncodeResourceList(&stdin, input); err != nil {
+		return nil, err
+	}
+
+	timeout, err := k.timeout()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, k.executable(), k.Args...)
+	cmd.Stdin = &stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	runErr := cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		runErr = fmt.Errorf("timed out after %s", timeout)
+	}
+	output, decodeErr := DecodeResourceList(&stdout)
+
+	var messages []string
+	if output != nil {
+		for _, result := range output.Results {
+			if result.Severity == "error" {
+				messages = append(messages, result.Message)
+			}
+		}
+	}
+	if runErr != nil || len(messages) > 0 {
+		if runErr != nil {
+			messages = append(messages, runErr.Error())
+		}
+		if s := strings.TrimSpace(stderr.String()); s != "" {
+			messages = append(messages, s)
+		}
+		return nil, fmt.Errorf("%s", strings.Join(messages, ": "))
+	}
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+	return output.Items, nil
+}
+
+// timeout parses Timeout, which is validated when the rule file is loaded.
+func (k KrmFunctionRule) timeout() (time.Duration, error) {
+	if k.Timeout == "" {
+		return defaultKrmFunctionTimeout, nil
+	}
+	timeout, err := time.ParseDuration(k.Timeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout %q: %v", k.Timeout, err)
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("invalid timeout %q: must be positive", k.Timeout)
+	}
+	return timeout, nil
+}
+
+func (k KrmFunctionRule) executable() string {
+	if k.Source != "" && !filepath.IsAbs(k.Exec) && strings.Contains(k.Exec, "/") {
+		return filepath.Join(filepath.Dir(k.Source), k.Exec)
+	}
+	return k.Exec
+}
+
+// savedAnnotations records what setAnnotations changed in an object, so that
+// it can be undone when the function returns the object.
+type savedAnnotations struct {
+	// values are the previous values of the annotations, nil for the
+	// annotations the object didn't have.
+	values map[string]*string
+	// createdMetadata and createdAnnotations are true if the maps didn't exist.
+	createdMetadata, createdAnnotations bool
+}
+
+func setAnnotations(item map[string]interface{}, values map[string]string) savedAnnotations {
+	saved := savedAnnotations{values: map[string]*string{}}
+	metadata, ok := item["metadata"].(map[string]interface{})
+	if !ok {
+		metadata = map[string]interface{}{}
+		item["metadata"] = metadata
+		saved.createdMetadata = true
+	}
+	annotations, ok := metadata["annotations"].(map[string]interface{})
+	if !ok {
+		annotations = map[string]interface{}{}
+		metadata["annotations"] = annotations
+		saved.createdAnnotations = true
+	}
+	for key, value := range values {
+		saved.values[key] = nil
+		if previous, ok := annotations[key].(string); ok {
+			saved.values[key] = &previous
+		}
+		annotations[key] = value
+	}
+	return saved
+}
+
+// restore resets the annotations set by setAnnotations to their previous
+// values, and removes the maps setAnnotations created if they are empty.
+func (s savedAnnotations) restore(item map[string]interface{}) {
+	metadata, _ := item["metadata"].(map[string]interface{})
+	annotations, _ := metadata["annotations"].(map[string]interface{})
+	if annotations == nil {
+		return
+	}
+	for key, value := range s.values {
+		if value != nil {
+			annotations[key] = *value
+		} else {
+			delete(annotations, key)
+		}
+	}
+	if s.createdAnnotations && len(annotations) == 0 {
+		delete(metadata, "annotations")
+	}
+	if s.createdMetadata && len(metadata) == 0 {
+		delete(item, "metadata")
+	}
+}
+
+// removeAnnotations removes the annotations of the ResourceList protocol from
+// an object added by the function, as well as the annotations and metadata
+// maps if removing them leaves the maps empty.
+func removeAnnotations(item map[string]interface{}, keys ...string) {
+	metadata, _ := item["metadata"].(map[string]interface{})
+	annotations, _ := metadata["annotations"].(map[string]interface{})
+	removed := false
+	for _, key := range keys {
+		if _, ok := annotations[key]; ok {
+			delete(annotations, key)
+			removed = true
+		}
+	}
+	if !removed {
+		return
+	}
+	if len(annotations) == 0 {
+		delete(metadata, "annotations")
+	}
+	if len(metadata) == 0 {
+		delete(item, "metadata")
+	}
+}
@@ -0,0 +1,181 @@
+package differ
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// TestMain runs the test binary as the KRM function of TestKrmFunctionRules
+// if YAML_PATCH_KRM_FUNCTION is set.
+func TestMain(m *testing.M) {
+	if os.Getenv("YAML_PATCH_KRM_FUNCTION") == "1" {
+		if err := krmFunctionHelper(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// krmFunctionHelper sets data.value from its functionConfig on ConfigMap a,
+// removes ConfigMap b and adds ConfigMap c. With noop in its functionConfig,
+// it returns the items unchanged, and with hang it never returns.
+func krmFunctionHelper() error {
+	input, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+	var resourceList map[string]interface{}
+	if err := yamlv3.Unmarshal(input, &resourceList); err != nil {
+		return err
+	}
+	config, _ := resourceList["functionConfig"].(map[string]interface{})
+	if config["fail"] == true {
+		return fmt.Errorf("failing as configured")
+	}
+	if config["hang"] == true {
+		fmt.Fprintln(os.Stderr, "hanging as configured")
+		time.Sleep(time.Hour)
+	}
+	if config["noop"] == true {
+		_, err := os.Stdout.Write(input)
+		return err
+	}
+
+	var items []interface{}
+	for _, item := range resourceList["items"].([]interface{}) {
+		switch item.(map[string]interface{})["metadata"].(map[string]interface{})["name"] {
+		case "a":
+			item.(map[string]interface{})["data"] = map[string]interface{}{"value": config["value"]}
+		case "b":
+			continue
+		}
+		items = append(items, item)
+	}
+	items = append(items, map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"name": "c"},
+	})
+	resourceList["items"] = items
+
+	output, err := yamlv3.Marshal(resourceList)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(output)
+	return err
+}
+
+func TestKrmFunctionRules(t *testing.T) {
+	executable, err := os.Executable()
+	require.NoError(t, err)
+	t.Setenv("YAML_PATCH_KRM_FUNCTION", "1")
+
+	apply := func(t *testing.T, rule KrmFunctionRule, objects []*YamlObject) ([]*YamlObject, *DebugInfo, error) {
+		ruleSet := RuleSet{Rules: []Rule{{KrmFunction: &rule}}}
+		debugInfo := NewDebugInfo(ruleSet)
+		debugInfo.AddInitialObjects(objects)
+		results, err := ApplyRuleSet(objects, ruleSet, debugInfo)
+		return results, debugInfo, err
+	}
+
+	t.Run("objects changed, removed and added by the function are tracked", func(t *testing.T) {
+		var config map[string]interface{}
+		require.NoError(t, yaml.Unmarshal([]byte("value: from-config"), &config))
+		results, debugInfo, err := apply(t, KrmFunctionRule{
+			Name:   "helper",
+			Exec:   executable,
+			Config: config,
+		}, []*YamlObject{newConfigMap("a", nil), newConfigMap("b", nil)})
+		require.NoError(t, err)
+		require.NoError(t, debugInfo.ValidateAllRulesWereEffective())
+
+		require.Len(t, results, 2)
+		require.Equal(t, "a.yaml", results[0].ResourceKey.Source)
+		value, err := results[0].Get("/data/value")
+		require.NoError(t, err)
+		require.Equal(t, "from-config", value)
+		_, err = results[0].Get("/metadata/annotations")
+		require.Error(t, err, "the annotations set for the function are removed")
+
+		require.Equal(t, "c", ObjectIdentityForObject(results[1]).Name)
+		ruleDebugInfo := debugInfo.RuleDebugInfos[0]
+		require.Len(t, ruleDebugInfo.Ignored, 1)
+		require.Len(t, ruleDebugInfo.Added, 1)
+	})
+
+	t.Run("failing functions fail the rule", func(t *testing.T) {
+		_, _, err := apply(t, KrmFunctionRule{
+			Name:   "failing",
+			Exec:   executable,
+			Config: map[string]interface{}{"fail": true},
+		}, []*YamlObject{newConfigMap("a", nil)})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `krm_function "failing"`)
+		require.Contains(t, err.Error(), "failing as configured")
+	})
+	t.Run("functions running too long are killed", func(t *testing.T) {
+		_, _, err := apply(t, KrmFunctionRule{
+			Name:    "hanging",
+			Exec:    executable,
+			Config:  map[string]interface{}{"hang": true},
+			Timeout: "200ms",
+		}, []*YamlObject{newConfigMap("a", nil)})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "timed out after 200ms")
+		require.Contains(t, err.Error(), "hanging as configured", "stderr is reported")
+	})
+
+	t.Run("annotations of the objects are restored", func(t *testing.T) {
+		newObject := func(doc string) *YamlObject {
+			obj := NewYamlObject("object.yaml")
+			require.NoError(t, DecodeYamlObject(strings.NewReader(doc), obj))
+			return obj
+		}
+		objects := func() []*YamlObject {
+			return []*YamlObject{
+				newObject(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: d
+  annotations:
+    config.k8s.io/id: "7"
+    config.kubernetes.io/path: package/d.yaml
+`),
+				newObject(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: e
+  annotations: {}
+`),
+			}
+		}
+
+		results, debugInfo, err := apply(t, KrmFunctionRule{
+			Name:   "noop",
+			Exec:   executable,
+			Config: map[string]interface{}{"noop": true},
+		}, objects())
+		require.NoError(t, err)
+		for i, obj := range objects() {
+			expected, err := obj.Document()
+			require.NoError(t, err)
+			actual, err := results[i].Document()
+			require.NoError(t, err)
+			require.Equal(t, expected, actual, "existing and empty annotations are kept")
+		}
+		require.Error(t, debugInfo.ValidateAllRulesWereEffective(), "a function changing nothing is ineffective")
+	})
+}
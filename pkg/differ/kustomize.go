@@ -0,0 +1,139 @@
+package differ
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+)
+
+// kustomizationFiles are the names kustomize looks for in a directory.
+var kustomizationFiles = []string{"kustomization.yaml", "kustomization.yml", "Kustomization"}
+
+// kustomizePatch is an entry of patches or patchesJson6902 in a
+// kustomization: a patch, inline or in a file, and the objects it applies to.
+type kustomizePatch struct {
+	Path   string  `yaml:"path"`
+	Patch  string  `yaml:"patch"`
+	Target *Target `yaml:"target"`
+}
+
+type kustomization struct {
+	Patches               []kustomizePatch `yaml:"patches"`
+	PatchesJson6902       []kustomizePatch `yaml:"patchesJson6902"`
+	PatchesStrategicMerge []interface{}    `yaml:"patchesStrategicMerge"`
+}
+
+// ImportKustomization converts the JSON 6902 patches of a kustomization, in
+// patches and patchesJson6902, into patch rules with the same target. path is
+// the kustomization file or the directory holding it. Strategic merge patches
+// have no equivalent in rules and are reported as errors.
+func ImportKustomization(path string) ([]Json6902PatchRule, error) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		file, err := findKustomization(path)
+		if err != nil {
+			return nil, err
+		}
+		path = file
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var k kustomization
+	if err := yaml.Unmarshal(buf, &k); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	var rules []Json6902PatchRule
+	var errs []error
+	if len(k.PatchesStrategicMerge) > 0 {
+		errs = append(errs, fmt.Errorf("%s: patchesStrategicMerge is not supported", path))
+	}
+	for _, field := range []struct {
+		key     string
+		patches []kustomizePatch
+	}{
+		{"patches", k.Patches},
+		{"patchesJson6902", k.PatchesJson6902},
+	} {
+		for i, patch := range field.patches {
+			entry := fmt.Sprintf("%s[%d]", field.key, i)
+			rule, err := importKustomizePatch(filepath.Dir(path), entry, patch)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s: %v", path, entry, err))
+				continue
+			}
+			rule.Source = path
+			rules = append(rules, rule)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, &MultiError{Errors: errs}
+	}
+	return rules, nil
+}
+
+func findKustomization(dir string) (string, error) {
+	for _, name := range kustomizationFiles {
+		file := filepath.Join(dir, name)
+		if _, err := os.Stat(file); err == nil {
+			return file, nil
+		}
+	}
+	return "", fmt.Errorf("no kustomization found in %s", dir)
+}
+
+func importKustomizePatch(dir, entry string, patch kustomizePatch) (Json6902PatchRule, error) {
+	if patch.Target == nil {
+		return Json6902PatchRule{}, fmt.Errorf("patches without a target are strategic merge patches, which are not supported")
+	}
+	if err := patch.Target.Validate(); err != nil {
+		return Json6902PatchRule{}, err
+	}
+
+	content, name := []byte(patch.Patch), entry
+	if patch.Path != "" {
+		buf, err := os.ReadFile(filepath.Join(dir, patch.Path))
+		if err != nil {
+			return Json6902PatchRule{}, err
+		}
+		content, name = buf, patch.Path
+	}
+
+	// JSON 6902 patches are lists of operations. Anything else is a
+	// strategic merge patch.
+	var steps Json6902Patch
+	if err := yaml.Unmarshal(content, &steps); err != nil || len(steps) == 0 {
+		return Json6902PatchRule{}, fmt.Errorf("only JSON 6902 patches are supported")
+	}
+	for _, step := range steps {
+		if !IsKnownOp(step.Op) {
+			return Json6902PatchRule{}, fmt.Errorf("unknown operation %q", step.Op)
+		}
+	}
+
+	return Json6902PatchRule{
+		Name:   name,
+		Target: patch.Target,
+		Steps:  steps,
+	}, nil
+}
+
+// WriteImportedRules prints imported rules as a rule file.
+func WriteImportedRules(w io.Writer, rules []Json6902PatchRule) error {
+	fmt.Fprintln(w, "patch_rules:")
+	for _, rule := range rules {
+		buf, err := yaml.Marshal([]Json6902PatchRule{rule})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "# imported from %s\n", rule.Source)
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,268 @@
+package differ
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// matchOps are the operations which, in addition to the RFC 6902 test
+// operation, can be used to match objects. They only test the object and can't
+// be used as steps.
+var matchOps = map[string]func(value interface{}, exists bool, expected interface{}) (bool, error){
+	"test_regex": func(value interface{}, exists bool, expected interface{}) (bool, error) {
+		re, err := compileRegexp(fmt.Sprint(expected))
+		if err != nil {
+			return false, err
+		}
+		return exists && isScalar(value) && re.MatchString(fmt.Sprint(value)), nil
+	},
+	"test_glob": func(value interface{}, exists bool, expected interface{}) (bool, error) {
+		re, err := globToRegexp(fmt.Sprint(expected))
+		if err != nil {
+			return false, err
+		}
+		return exists && isScalar(value) && re.MatchString(fmt.Sprint(value)), nil
+	},
+	"test_prefix": func(value interface{}, exists bool, expected interface{}) (bool, error) {
+		return exists && isScalar(value) && strings.HasPrefix(fmt.Sprint(value), fmt.Sprint(expected)), nil
+	},
+	"test_in": func(value interface{}, exists bool, expected interface{}) (bool, error) {
+		candidates, ok := expected.([]interface{})
+		if !ok {
+			return false, fmt.Errorf("test_in requires a list value, got %v", expected)
+		}
+		for _, candidate := range candidates {
+			if exists && reflect.DeepEqual(value, candidate) {
+				return true, nil
+			}
+		}
+		return false, nil
+	},
+	"test_exists": func(value interface{}, exists bool, expected interface{}) (bool, error) {
+		return exists, nil
+	},
+	"test_absent": func(value interface{}, exists bool, expected interface{}) (bool, error) {
+		return !exists, nil
+	},
+	"test_gt": func(value interface{}, exists bool, expected interface{}) (bool, error) {
+		return compareNumbers(value, exists, expected, func(a, b float64) bool { return a > b })
+	},
+	"test_lt": func(value interface{}, exists bool, expected interface{}) (bool, error) {
+		return compareNumbers(value, exists, expected, func(a, b float64) bool { return a < b })
+	},
+}
+
+// regexps caches compiled regular expressions by pattern, since match
+// operations are evaluated once per object.
+var regexps sync.Map
+
+// compileRegexp compiles the pattern of a test_regex or substitute operation.
+// Patterns are validated when rule files are loaded.
+func compileRegexp(pattern string) (*regexp.Regexp, error) {
+	if re, ok := regexps.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression %q: %v", pattern, err)
+	}
+	regexps.Store(pattern, re)
+	return re, nil
+}
+
+// IsMatchOp returns true if op is one of the match-only operations.
+func IsMatchOp(op string) bool {
+	_, ok := matchOps[op]
+	return ok
+}
+
+// matchValue evaluates a match-only operation. For an extended path, the
+// operation matches if it matches at least one expansion, except for
+// test_absent, which matches only if none of the expansions exist.
+func (j Json6902Operation) matchValue(obj *YamlObject) (bool, []string, error) {
+	doc, err := obj.Document()
+	if err != nil {
+		return false, nil, err
+	}
+
+	pointers := []string{j.Path}
+	if IsExtendedPath(j.Path) {
+		expansions, err := expandPath(doc, j.Path)
+		if err != nil {
+			return false, nil, err
+		}
+		pointers = pointers[:0]
+		for _, e := range expansions {
+			pointers = append(pointers, e.pointer)
+		}
+	}
+
+	expected, err := normalizeValue(j.Value)
+	if err != nil {
+		return false, nil, err
+	}
+
+	if j.Op == "test_absent" {
+		for _, pointer := range pointers {
+			if _, exists := resolvePointer(doc, pointer); exists {
+				return false, nil, nil
+			}
+		}
+		return true, nil, nil
+	}
+
+	var paths []string
+	for _, pointer := range pointers {
+		value, exists := resolvePointer(doc, pointer)
+		matches, err := matchOps[j.Op](value, exists, expected)
+		if err != nil {
+			return false, nil, fmt.Errorf("%s: %w", j, err)
+		}
+		if matches {
+			paths = append(paths, pointer)
+		}
+	}
+	return len(paths) > 0, paths, nil
+}
+
+// resolvePointer returns the value at a JSON pointer in a document.
+func resolvePointer(doc interface{}, pointer string) (interface{}, bool) {
+	if pointer == "" {
+		return doc, true
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, false
+	}
+	for _, token := range strings.Split(pointer[1:], "/") {
+		switch v := doc.(type) {
+		case map[string]interface{}:
+			child, ok := v[unescapePointerToken(token)]
+			if !ok {
+				return nil, false
+			}
+			doc = child
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			doc = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+// normalizeValue converts a value from a rule file into the plain JSON form
+// of documents, so that both can be compared.
+func normalizeValue(value interface{}) (interface{}, error) {
+	buf, err := json.Marshal(jsonCompatible(value))
+	if err != nil {
+		return nil, err
+	}
+	var result interface{}
+	err = json.Unmarshal(buf, &result)
+	return result, err
+}
+
+func isScalar(value interface{}) bool {
+	switch value.(type) {
+	case map[string]interface{}, []interface{}, nil:
+		return false
+	}
+	return true
+}
+
+func compareNumbers(value interface{}, exists bool, expected interface{}, compare func(a, b float64) bool) (bool, error) {
+	threshold, ok := toNumber(expected)
+	if !ok {
+		return false, fmt.Errorf("%v is not a number", expected)
+	}
+	if !exists {
+		return false, nil
+	}
+	number, ok := toNumber(value)
+	return ok && compare(number, threshold), nil
+}
+
+func toNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// globToRegexp converts a glob where "*" matches any sequence of characters
+// and "?" any single character into an anchored regular expression.
+func globToRegexp(glob string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return compileRegexp(sb.String())
+}
+
+// matcher is a matcher with several values, which an object matches if it
+// matches any of them.
+type matcher struct {
+	Path         string
+	Alternatives Json6902Patch
+}
+
+// describe describes the conditions of the matcher. With eachValue, every
+// value is a condition of its own, followed by the matcher itself.
+func (m matcher) describe(eachValue bool) []string {
+	var values, conditions []string
+	for _, alternative := range m.Alternatives {
+		value := fmt.Sprint(alternative.Value)
+		if alternative.Op != "test" {
+			value = alternative.Op + " " + value
+		}
+		values = append(values, value)
+		if eachValue {
+			conditions = append(conditions, m.Path+": "+value)
+		}
+	}
+	return append(conditions, m.Path+": one of ["+strings.Join(values, ", ")+"]")
+}
+
+// matches returns true if the object matches one of the values of the
+// matcher, recording its conditions from the given index. Values which fail
+// to evaluate don't match, like match steps.
+func (m matcher) matches(obj *YamlObject, eachValue bool, condition int, debug *RuleDebugInfo) bool {
+	matched := false
+	for i, alternative := range m.Alternatives {
+		ok, _, err := alternative.matchConcrete(obj)
+		if err != nil || !ok {
+			continue
+		}
+		matched = true
+		if !eachValue {
+			break
+		}
+		debug.RecordConditionMatch(condition+i, obj)
+	}
+	if matched {
+		debug.RecordConditionMatch(condition+len(m.describe(eachValue))-1, obj)
+	}
+	return matched
+}
@@ -0,0 +1,58 @@
+package differ
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchOps(t *testing.T) {
+	object := newDeploymentWithSidecar()
+	err := object.Set("/spec/replicas", 3)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		op      Json6902Operation
+		matches bool
+	}{
+		{Json6902Operation{Op: "test_regex", Path: "/metadata/name", Value: "^inges"}, true},
+		{Json6902Operation{Op: "test_regex", Path: "/metadata/name", Value: "^querier$"}, false},
+		{Json6902Operation{Op: "test_glob", Path: "/spec/template/spec/containers/*/image", Value: "grafana/mimir:*"}, true},
+		{Json6902Operation{Op: "test_glob", Path: "/metadata/name", Value: "*-zone-a"}, false},
+		{Json6902Operation{Op: "test_prefix", Path: "/spec/template/spec/containers[name=ingester]/image", Value: "grafana/"}, true},
+		{Json6902Operation{Op: "test_in", Path: "/kind", Value: []interface{}{"StatefulSet", "Deployment"}}, true},
+		{Json6902Operation{Op: "test_in", Path: "/spec/replicas", Value: []interface{}{1, 2}}, false},
+		{Json6902Operation{Op: "test_exists", Path: "/spec/template/spec/containers/*/resources"}, true},
+		{Json6902Operation{Op: "test_exists", Path: "/spec/serviceName"}, false},
+		{Json6902Operation{Op: "test_absent", Path: "/spec/serviceName"}, true},
+		{Json6902Operation{Op: "test_absent", Path: "/spec/template/spec/containers/*/resources"}, false},
+		{Json6902Operation{Op: "test_gt", Path: "/spec/replicas", Value: 1}, true},
+		{Json6902Operation{Op: "test_lt", Path: "/spec/replicas", Value: 3}, false},
+		{Json6902Operation{Op: "test_lt", Path: "/metadata/name", Value: 3}, false},
+	} {
+		t.Run(tc.op.String(), func(t *testing.T) {
+			matches, err := Json6902Patch{tc.op}.Matches(object, nil)
+			require.NoError(t, err)
+			require.Equal(t, tc.matches, matches)
+		})
+	}
+
+	t.Run("match ops can't be used as steps", func(t *testing.T) {
+		err := Json6902Patch{{Op: "test_exists", Path: "/kind"}}.ApplyToObject(object, nil)
+		require.Error(t, err)
+	})
+
+	t.Run("matcher sugar accepts match ops", func(t *testing.T) {
+		rules := Desugar(Json6902PatchRule{
+			RemoveField: "/spec/replicas",
+			Matchers: map[string][]interface{}{
+				"/metadata/name": {map[interface{}]interface{}{"test_glob": "*-zone-a"}, "ingester"},
+			},
+		})
+		require.Len(t, rules, 1)
+		require.Equal(t, []matcher{{Path: "/metadata/name", Alternatives: Json6902Patch{
+			{Op: "test_glob", Path: "/metadata/name", Value: "*-zone-a"},
+			{Op: "test", Path: "/metadata/name", Value: "ingester"},
+		}}}, rules[0].matchers)
+	})
+}
@@ -0,0 +1,218 @@
+package differ
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// absentValue is the matrix value of a field or object missing in an input.
+const absentValue = "<absent>"
+
+// MatrixRow describes a single field of an object on which the inputs do not
+// all agree. Values holds the formatted value of the field in each input.
+type MatrixRow struct {
+	Identity ObjectIdentity
+	// Path is empty for the row describing whether the object exists at all.
+	Path     string
+	Values   []string
+	Majority string
+	// Outliers are the indices of the inputs that don't have the majority value.
+	Outliers []int
+}
+
+// BuildMatrix compares any number of inputs with each other. For every object
+// identity and every field whose value is not the same in all inputs, a row
+// is produced listing the value in each input, the value most inputs agree on
+// and the inputs which deviate from it. Documents embedded in the data of
+// ConfigMaps and Secrets are compared field by field, like in DiffObjects.
+// Values of Secret data are redacted unless opts.ShowSecrets is set.
+func BuildMatrix(inputs [][]*YamlObject, opts DiffOptions) ([]MatrixRow, error) {
+	var objects = map[ObjectIdentity][]*YamlObject{}
+	for i, input := range inputs {
+		for _, obj := range input {
+			id := ObjectIdentityForObject(obj)
+			if objects[id] == nil {
+				objects[id] = make([]*YamlObject, len(inputs))
+			}
+			objects[id][i] = obj
+		}
+	}
+
+	var ids []ObjectIdentity
+	for id := range objects {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+
+	var rows []MatrixRow
+	for _, id := range ids {
+		presence := make([]string, len(inputs))
+		fields := make([]map[string]string, len(inputs))
+		var paths []string
+		var seen = map[string]bool{}
+		for i, obj := range objects[id] {
+			if obj == nil {
+				presence[i] = absentValue
+				continue
+			}
+			presence[i] = "present"
+			doc, err := obj.Document()
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", obj.ResourceKey, err)
+			}
+			fields[i] = flattenDocument(id.Kind, doc, opts)
+			for path := range fields[i] {
+				if !seen[path] {
+					seen[path] = true
+					paths = append(paths, path)
+				}
+			}
+		}
+		sort.Strings(paths)
+
+		if row, ok := newMatrixRow(id, "", presence, nil); ok {
+			rows = append(rows, row)
+		}
+		// Fields are only compared between the inputs containing the object,
+		// its absence is already reported above.
+		for _, path := range paths {
+			values := make([]string, len(inputs))
+			for i := range values {
+				if fields[i] == nil {
+					continue
+				}
+				value, ok := fields[i][path]
+				if !ok {
+					value = absentValue
+				}
+				values[i] = value
+			}
+			if row, ok := newMatrixRow(id, path, values, fields); ok {
+				rows = append(rows, row)
+			}
+		}
+	}
+	return rows, nil
+}
+
+// newMatrixRow returns a row for the values, unless they all agree. If fields
+// is set, only the inputs with fields are considered. Ties for the majority
+// are broken in favour of the value of the earliest input.
+func newMatrixRow(id ObjectIdentity, path string, values []string, fields []map[string]string) (MatrixRow, bool) {
+	var considered = func(i int) bool {
+		return fields == nil || fields[i] != nil
+	}
+
+	var counts = map[string]int{}
+	var majority string
+	var total int
+	for i, v := range values {
+		if !considered(i) {
+			continue
+		}
+		total++
+		counts[v]++
+		if counts[v] > counts[majority] {
+			majority = v
+		}
+	}
+	if counts[majority] == total {
+		return MatrixRow{}, false
+	}
+
+	row := MatrixRow{Identity: id, Path: path, Values: values, Majority: majority}
+	for i, v := range values {
+		if considered(i) && v != majority {
+			row.Outliers = append(row.Outliers, i)
+		}
+	}
+	return row, true
+}
+
+// flattenDocument returns the formatted value of every leaf of a document by
+// JSON pointer. Embedded documents in ConfigMap and Secret data are
+// flattened as well, with their paths suffixed to the data path after "#".
+func flattenDocument(kind string, doc interface{}, opts DiffOptions) map[string]string {
+	var result = map[string]string{}
+	var walk func(value interface{}, path string, embedded bool)
+	walk = func(value interface{}, path string, embedded bool) {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if len(v) == 0 {
+				break
+			}
+			for k, item := range v {
+				walk(item, path+"/"+escapePointerToken(k), embedded)
+			}
+			return
+		case []interface{}:
+			if len(v) == 0 {
+				break
+			}
+			for i, item := range v {
+				walk(item, path+"/"+strconv.Itoa(i), embedded)
+			}
+			return
+		case string:
+			if embedded || (kind != "ConfigMap" && kind != "Secret") || !isDataPath(kind, path) {
+				break
+			}
+			text := v
+			if kind == "Secret" && strings.HasPrefix(path, "/data/") {
+				text = decodeBase64OrRaw(text)
+			}
+			if embeddedDoc, ok := parseEmbeddedDocument(text); ok {
+				walk(embeddedDoc, path+"#", true)
+				return
+			}
+			value = text
+		}
+		if kind == "Secret" && !opts.ShowSecrets && isSecretDataPath(path) {
+			result[path] = redactValue(value)
+			return
+		}
+		result[path] = FormatValue(value)
+	}
+	walk(doc, "", false)
+	return result
+}
+
+// WriteMatrix prints the rows as a table with one column per input. Inputs
+// agreeing with the majority are shown as "=", inputs which don't contain the
+// object at all as "-".
+func WriteMatrix(w io.Writer, inputNames []string, rows []MatrixRow) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "OBJECT\tPATH\tMAJORITY\t%s\n", strings.Join(inputNames, "\t"))
+	for _, row := range rows {
+		cells := make([]string, len(row.Values))
+		for i := range cells {
+			cells[i] = "="
+			if row.Values[i] == "" {
+				cells[i] = "-"
+			}
+		}
+		for _, i := range row.Outliers {
+			cells[i] = truncate(row.Values[i], 60)
+		}
+		path := row.Path
+		if path == "" {
+			path = "(object)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", row.Identity, path, truncate(row.Majority, 60), strings.Join(cells, "\t"))
+	}
+	return tw.Flush()
+}
+
+// truncate shortens s to at most length runes, so that multi-byte characters
+// are never cut in half.
+func truncate(s string, length int) string {
+	runes := []rune(s)
+	if len(runes) <= length {
+		return s
+	}
+	return string(runes[:length-3]) + "..."
+}
@@ -0,0 +1,76 @@
+package differ
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildMatrix(t *testing.T) {
+	dev := []*YamlObject{
+		newConfigMap("mimir-config", map[string]interface{}{"mimir.yaml": "target: all\nreplication_factor: 1\n"}),
+		newDeploymentWithLabels("querier", map[string]string{"name": "querier"}),
+	}
+	staging := []*YamlObject{
+		newConfigMap("mimir-config", map[string]interface{}{"mimir.yaml": "target: all\nreplication_factor: 3\n"}),
+		newDeploymentWithLabels("querier", map[string]string{"name": "querier"}),
+	}
+	prod := []*YamlObject{
+		newConfigMap("mimir-config", map[string]interface{}{"mimir.yaml": "target: all\nreplication_factor: 3\n"}),
+	}
+
+	rows, err := BuildMatrix([][]*YamlObject{dev, staging, prod}, DiffOptions{})
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+
+	require.Equal(t, "ConfigMap/default/mimir-config", rows[0].Identity.String())
+	require.Equal(t, "/data/mimir.yaml#/replication_factor", rows[0].Path)
+	require.Equal(t, []string{"1", "3", "3"}, rows[0].Values)
+	require.Equal(t, "3", rows[0].Majority)
+	require.Equal(t, []int{0}, rows[0].Outliers)
+
+	require.Equal(t, "Deployment/default/querier", rows[1].Identity.String())
+	require.Equal(t, "", rows[1].Path)
+	require.Equal(t, "present", rows[1].Majority)
+	require.Equal(t, []int{2}, rows[1].Outliers)
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, WriteMatrix(buf, []string{"dev", "staging", "prod"}, rows))
+	require.Equal(t, `OBJECT                          PATH                                  MAJORITY  dev  staging  prod
+ConfigMap/default/mimir-config  /data/mimir.yaml#/replication_factor  3         1    =        =
+Deployment/default/querier      (object)                              present   =    =        <absent>
+`, buf.String())
+}
+
+func TestBuildMatrixSecrets(t *testing.T) {
+	newSecret := func(password string) []*YamlObject {
+		secret := newConfigMap("mimir-secret", map[string]interface{}{"password": base64.StdEncoding.EncodeToString([]byte(password))})
+		secret.Object["kind"] = "Secret"
+		return []*YamlObject{secret}
+	}
+	inputs := [][]*YamlObject{newSecret("hunter2"), newSecret("hunter3"), newSecret("hunter3")}
+
+	rows, err := BuildMatrix(inputs, DiffOptions{})
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	require.Equal(t, "/data/password", rows[0].Path)
+	require.Equal(t, []int{0}, rows[0].Outliers, "redacted values are still compared")
+	for _, value := range rows[0].Values {
+		require.Contains(t, value, "<redacted ")
+		require.NotContains(t, value, "hunter")
+	}
+
+	rows, err = BuildMatrix(inputs, DiffOptions{ShowSecrets: true})
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	require.Contains(t, rows[0].Majority, "hunter3")
+	require.Contains(t, rows[0].Values[0], "hunter2")
+}
+
+func TestTruncate(t *testing.T) {
+	require.Equal(t, "querier", truncate("querier", 10))
+	require.Equal(t, "ingest...", truncate("ingester-zone-a", 9))
+	require.Equal(t, "zoné-ä...", truncate("zoné-ä-ingester", 9), "multi-byte characters are not cut in half")
+}
@@ -1,5 +1,7 @@
 package differ
 
+import "fmt"
+
 // ResourceKey represents a unique identifier for any object
 type ResourceKey struct {
 	Source string
@@ -13,26 +15,83 @@ func ResourceKeyForObject(obj *YamlObject) ResourceKey {
 	return obj.ResourceKey
 }
 
+// Input is a named set of objects, e.g. the objects read from an -input-dir.
+type Input struct {
+	Name    string
+	Objects []*YamlObject
+}
+
 func ApplyRuleSet(objects []*YamlObject, ruleSet RuleSet, debugInfo *DebugInfo) ([]*YamlObject, error) {
-	var err error
-	for i, ir := range ruleSet.IgnoreRules {
-		objects, err = MapObjects(objects, ir, debugInfo.NewRuleDebugInfo(i, ir))
-		if err != nil {
-			return nil, err
-		}
+	results, err := ApplyRuleSetToInputs([]Input{{Objects: objects}}, ruleSet, debugInfo)
+	if err != nil {
+		return nil, err
 	}
+	return results[0], nil
+}
 
-	for i, pr := range ruleSet.PatchRules {
-		objects, err = MapObjects(objects, pr, debugInfo.NewRuleDebugInfo(len(ruleSet.IgnoreRules)+i, pr))
-		if err != nil {
-			return nil, err
+// ApplyRuleSetToInputs applies every rule to all inputs before moving on to the
+// next rule, in the order of RuleSet.ObjectRules. Rules restricted to some
+// inputs skip the others. Rules with conditions on the other side require
+// exactly two inputs, each is then evaluated against the paired objects of the
+// other input as they were before the rule was applied to either. Debug
+// information is recorded per input, see DebugInfo.ForInput.
+func ApplyRuleSetToInputs(inputs []Input, ruleSet RuleSet, debugInfo *DebugInfo) ([][]*YamlObject, error) {
+	rules := ruleSet.ObjectRules()
+
+	results := make([][]*YamlObject, len(inputs))
+	for j, input := range inputs {
+		results[j] = input.Objects
+	}
+
+	for i, rule := range rules {
+		var contexts []*RuleContext
+		if cr, ok := rule.(ContextualRule); ok && cr.NeedsOtherSide() {
+			if len(results) != 2 {
+				return nil, fmt.Errorf("rule %q has conditions on the other side, which requires exactly two inputs", rule.Describe().Name)
+			}
+			contexts = []*RuleContext{
+				{OtherSide: indexByIdentity(results[1])},
+				{OtherSide: indexByIdentity(results[0])},
+			}
+		}
+
+		for j, input := range inputs {
+			if !appliesToInput(rule, input.Name) {
+				continue
+			}
+			mapper := rule
+			if contexts != nil {
+				mapper = rule.(ContextualRule).WithContext(contexts[j])
+			}
+			var err error
+			results[j], err = MapObjects(results[j], mapper, debugInfo.ForInput(input.Name).NewRuleDebugInfo(i, rule))
+			if err != nil {
+				return nil, err
+			}
 		}
 	}
 
-	return objects, nil
+	return results, nil
+}
+
+func appliesToInput(rule ObjectRule, input string) bool {
+	inputs := rule.Describe().Inputs
+	if len(inputs) == 0 {
+		return true
+	}
+	for _, name := range inputs {
+		if name == input {
+			return true
+		}
+	}
+	return false
 }
 
 func MapObjects(state []*YamlObject, mapper ObjectRule, ruleDebugInfo *RuleDebugInfo) ([]*YamlObject, error) {
+	if setMapper, ok := mapper.(ObjectSetRule); ok {
+		return setMapper.MapObjectSet(state, ruleDebugInfo)
+	}
+
 	result := []*YamlObject{}
 	for _, obj := range state {
 		mapped, err := mapper.MapObject(obj, ruleDebugInfo)
@@ -0,0 +1,139 @@
+package differ
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// RuleContext holds what a rule may know about the inputs beyond the object
+// it is applied to.
+type RuleContext struct {
+	// OtherSide holds the objects of the other input by identity, as they were
+	// before the rule was applied. It is nil unless exactly two inputs are
+	// processed together.
+	OtherSide map[ObjectIdentity]*YamlObject
+}
+
+// ContextualRule is implemented by rules whose conditions depend on the
+// inputs being processed. ApplyRuleSetToInputs binds such rules to a context
+// for each input before applying them.
+type ContextualRule interface {
+	ObjectRule
+	// NeedsOtherSide returns true if the rule has conditions on the other input.
+	NeedsOtherSide() bool
+	// WithContext returns a copy of the rule which evaluates its conditions
+	// in the given context.
+	WithContext(ctx *RuleContext) ObjectRule
+}
+
+// OtherSideCondition restricts a rule to objects depending on the object with
+// the same identity in the other input, called the paired object.
+type OtherSideCondition struct {
+	// OtherSide is "absent" to only match objects without a paired object, or
+	// "present" to only match objects with one.
+	OtherSide string `yaml:"other_side,omitempty"`
+	// OtherSideEquals only matches objects whose value at this path equals the
+	// value in the paired object.
+	OtherSideEquals string `yaml:"other_side_equals,omitempty"`
+	// OtherSideMissing only matches objects whose paired object is absent or
+	// has no value at this path.
+	OtherSideMissing string `yaml:"other_side_missing,omitempty"`
+}
+
+// IsSet returns true if any condition on the other side is set.
+func (c OtherSideCondition) IsSet() bool {
+	return c.OtherSide != "" || c.OtherSideEquals != "" || c.OtherSideMissing != ""
+}
+
+// Describe returns a description of each condition that is set, in the order
+// they are evaluated.
+func (c OtherSideCondition) Describe() []string {
+	var result []string
+	if c.OtherSide != "" {
+		result = append(result, "other_side: "+c.OtherSide)
+	}
+	if c.OtherSideEquals != "" {
+		result = append(result, "other_side_equals: "+c.OtherSideEquals)
+	}
+	if c.OtherSideMissing != "" {
+		result = append(result, "other_side_missing: "+c.OtherSideMissing)
+	}
+	return result
+}
+
+// Matches evaluates the conditions in order, recording each one passed in the
+// debug info. Without a context, no condition can be evaluated and none of
+// them matches.
+func (c OtherSideCondition) Matches(obj *YamlObject, ctx *RuleContext, debug *RuleDebugInfo) (bool, error) {
+	if !c.IsSet() {
+		return true, nil
+	}
+	if ctx == nil || ctx.OtherSide == nil {
+		return false, nil
+	}
+
+	other := ctx.OtherSide[ObjectIdentityForObject(obj)]
+	var checks []func() (bool, error)
+	if c.OtherSide != "" {
+		checks = append(checks, func() (bool, error) {
+			switch c.OtherSide {
+			case "absent":
+				return other == nil, nil
+			case "present":
+				return other != nil, nil
+			}
+			return false, fmt.Errorf("other_side must be absent or present, got %q", c.OtherSide)
+		})
+	}
+	if c.OtherSideEquals != "" {
+		checks = append(checks, func() (bool, error) {
+			if other == nil {
+				return false, nil
+			}
+			value, exists, err := valueAt(obj, c.OtherSideEquals)
+			if err != nil || !exists {
+				return false, err
+			}
+			otherValue, otherExists, err := valueAt(other, c.OtherSideEquals)
+			if err != nil {
+				return false, err
+			}
+			return otherExists && reflect.DeepEqual(value, otherValue), nil
+		})
+	}
+	if c.OtherSideMissing != "" {
+		checks = append(checks, func() (bool, error) {
+			if other == nil {
+				return true, nil
+			}
+			_, otherExists, err := valueAt(other, c.OtherSideMissing)
+			return !otherExists, err
+		})
+	}
+
+	for i, check := range checks {
+		ok, err := check()
+		if err != nil || !ok {
+			return false, err
+		}
+		debug.RecordConditionMatch(i, obj)
+	}
+	return true, nil
+}
+
+func valueAt(obj *YamlObject, pointer string) (interface{}, bool, error) {
+	doc, err := obj.Document()
+	if err != nil {
+		return nil, false, err
+	}
+	value, exists := resolvePointer(doc, pointer)
+	return value, exists, nil
+}
+
+func indexByIdentity(objects []*YamlObject) map[ObjectIdentity]*YamlObject {
+	result := make(map[ObjectIdentity]*YamlObject, len(objects))
+	for _, obj := range objects {
+		result[ObjectIdentityForObject(obj)] = obj.DeepCopy()
+	}
+	return result
+}
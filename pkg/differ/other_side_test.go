@@ -0,0 +1,106 @@
+package differ
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestOtherSideConditions(t *testing.T) {
+	newInputs := func() []Input {
+		return []Input{
+			{Name: "left", Objects: []*YamlObject{
+				newConfigMap("only-left", map[string]interface{}{"a": "1"}),
+				newConfigMap("both", map[string]interface{}{"a": "1", "b": "2"}),
+			}},
+			{Name: "right", Objects: []*YamlObject{
+				newConfigMap("both", map[string]interface{}{"a": "1"}),
+			}},
+		}
+	}
+
+	loadRuleSet := func(t *testing.T, rules string) RuleSet {
+		var ruleSet RuleSet
+		require.NoError(t, yaml.Unmarshal([]byte(rules), &ruleSet))
+		ruleSet.Desugar()
+		return ruleSet
+	}
+
+	t.Run("objects absent on the other side are ignored", func(t *testing.T) {
+		ruleSet := loadRuleSet(t, `
+ignore_rules:
+- name: only on one side
+  match:
+  - {op: test, path: /kind, value: ConfigMap}
+  other_side: absent
+`)
+		debugInfo := NewDebugInfo(ruleSet)
+		inputs := newInputs()
+		for _, input := range inputs {
+			debugInfo.ForInput(input.Name).AddInitialObjects(input.Objects)
+		}
+
+		results, err := ApplyRuleSetToInputs(inputs, ruleSet, debugInfo)
+		require.NoError(t, err)
+		require.NoError(t, debugInfo.ValidateAllRulesWereEffective())
+		require.Len(t, results[0], 1)
+		require.Equal(t, "both", ObjectIdentityForObject(results[0][0]).Name)
+		require.Len(t, results[1], 1)
+	})
+
+	t.Run("fields are only removed if the other side does not have them", func(t *testing.T) {
+		ruleSet := loadRuleSet(t, `
+patch_rules:
+- remove_field: /data/b
+  other_side_missing: /data/b
+  other_side_equals: /data/a
+`)
+		debugInfo := NewDebugInfo(ruleSet)
+		inputs := newInputs()
+		for _, input := range inputs {
+			debugInfo.ForInput(input.Name).AddInitialObjects(input.Objects)
+		}
+
+		results, err := ApplyRuleSetToInputs(inputs, ruleSet, debugInfo)
+		require.NoError(t, err)
+		require.NoError(t, debugInfo.ValidateAllRulesWereEffective())
+
+		diffs, err := DiffObjectSets(results[0], results[1], DiffOptions{})
+		require.NoError(t, err)
+		for _, d := range diffs {
+			if d.Identity.Name == "both" {
+				require.Empty(t, d.Differences)
+			}
+		}
+	})
+
+	t.Run("conditions that never hold are reported", func(t *testing.T) {
+		ruleSet := loadRuleSet(t, `
+ignore_rules:
+- name: never
+  match:
+  - {op: test, path: /metadata/name, value: both}
+  other_side: absent
+`)
+		debugInfo := NewDebugInfo(ruleSet)
+		inputs := newInputs()
+		debugInfo.ForInput("left").AddInitialObjects(inputs[0].Objects)
+
+		_, err := ApplyRuleSetToInputs(inputs, ruleSet, debugInfo)
+		require.NoError(t, err)
+		err = debugInfo.ValidateAllRulesWereEffective()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "other_side: absent did not hold")
+	})
+
+	t.Run("conditions on the other side require two inputs", func(t *testing.T) {
+		ruleSet := loadRuleSet(t, `
+ignore_rules:
+- name: only on one side
+  other_side: absent
+`)
+		_, err := ApplyRuleSet(newInputs()[0].Objects, ruleSet, nil)
+		require.Error(t, err)
+	})
+}
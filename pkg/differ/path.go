@@ -0,0 +1,186 @@
+package differ
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Paths in rules are JSON pointers extended with two kinds of segments that
+// select several locations at once:
+//
+//   - "*" selects every element of a list or every value of a map, e.g.
+//     /spec/template/spec/containers/*/resources
+//   - "[key=value]" directly after a segment selects the elements of the list
+//     at that segment whose key equals value, e.g.
+//     /spec/template/spec/containers[name=ingester]/image
+//
+// Such paths are expanded into one concrete JSON pointer per selected
+// location before an operation is applied.
+
+type pathSegmentType int
+
+const (
+	literalSegment pathSegmentType = iota
+	wildcardSegment
+	predicateSegment
+)
+
+type pathSegment struct {
+	typ   pathSegmentType
+	token string // escaped token of a literal segment
+	key   string // key and value of a predicate segment
+	value string
+}
+
+// IsExtendedPath returns true if the path contains wildcards or key
+// predicates and has to be expanded before use.
+func IsExtendedPath(path string) bool {
+	for _, token := range strings.Split(path, "/") {
+		if token == "*" || (strings.Contains(token, "[") && strings.HasSuffix(token, "]")) {
+			return true
+		}
+	}
+	return false
+}
+
+func parsePath(path string) ([]pathSegment, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("path %q must start with /", path)
+	}
+
+	var segments []pathSegment
+	for _, token := range strings.Split(path[1:], "/") {
+		if token == "*" {
+			segments = append(segments, pathSegment{typ: wildcardSegment})
+			continue
+		}
+		open := strings.Index(token, "[")
+		if open < 0 || !strings.HasSuffix(token, "]") {
+			segments = append(segments, pathSegment{typ: literalSegment, token: token})
+			continue
+		}
+		predicate := strings.SplitN(token[open+1:len(token)-1], "=", 2)
+		if len(predicate) != 2 || predicate[0] == "" {
+			return nil, fmt.Errorf("invalid key predicate %q in path %q, expected [key=value]", token[open:], path)
+		}
+		if open > 0 {
+			segments = append(segments, pathSegment{typ: literalSegment, token: token[:open]})
+		}
+		segments = append(segments, pathSegment{typ: predicateSegment, key: predicate[0], value: predicate[1]})
+	}
+	return segments, nil
+}
+
+// pathExpansion is a concrete JSON pointer together with the tokens chosen for
+// each wildcard and predicate of the extended path it was expanded from.
+type pathExpansion struct {
+	pointer  string
+	bindings []string
+}
+
+// expandPath resolves the wildcards and predicates of an extended path
+// against a document. Literal segments are kept as is, even if they don't
+// exist in the document, so that the expansions can be used to add fields.
+func expandPath(doc interface{}, path string) ([]pathExpansion, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []pathExpansion
+	var walk func(value interface{}, segments []pathSegment, pointer string, bindings []string)
+	walk = func(value interface{}, segments []pathSegment, pointer string, bindings []string) {
+		if len(segments) == 0 {
+			result = append(result, pathExpansion{pointer: pointer, bindings: bindings})
+			return
+		}
+
+		var bind = func(token string, child interface{}) {
+			next := make([]string, len(bindings), len(bindings)+1)
+			copy(next, bindings)
+			walk(child, segments[1:], pointer+"/"+token, append(next, token))
+		}
+
+		segment := segments[0]
+		switch segment.typ {
+		case literalSegment:
+			walk(childValue(value, segment.token), segments[1:], pointer+"/"+segment.token, bindings)
+		case wildcardSegment:
+			switch v := value.(type) {
+			case map[string]interface{}:
+				keys := make([]string, 0, len(v))
+				for k := range v {
+					keys = append(keys, k)
+				}
+				sort.Strings(keys)
+				for _, k := range keys {
+					bind(escapePointerToken(k), v[k])
+				}
+			case []interface{}:
+				for i, item := range v {
+					bind(strconv.Itoa(i), item)
+				}
+			}
+		case predicateSegment:
+			items, _ := value.([]interface{})
+			for i, item := range items {
+				m, ok := item.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				if field, ok := m[segment.key]; ok && fmt.Sprint(field) == segment.value {
+					bind(strconv.Itoa(i), item)
+				}
+			}
+		}
+	}
+	walk(doc, segments, "", nil)
+	return result, nil
+}
+
+// substitutePath replaces the wildcards and predicates of an extended path by
+// the bindings of an expansion of another path, in order. This is used to
+// derive the target of a move or copy from the expansion of its source.
+func substitutePath(path string, bindings []string) (string, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for _, segment := range segments {
+		sb.WriteString("/")
+		if segment.typ == literalSegment {
+			sb.WriteString(segment.token)
+			continue
+		}
+		if len(bindings) == 0 {
+			return "", fmt.Errorf("path %q has more wildcards and predicates than its source", path)
+		}
+		sb.WriteString(bindings[0])
+		bindings = bindings[1:]
+	}
+	return sb.String(), nil
+}
+
+func childValue(value interface{}, token string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v[unescapePointerToken(token)]
+	case []interface{}:
+		i, err := strconv.Atoi(token)
+		if err != nil || i < 0 || i >= len(v) {
+			return nil
+		}
+		return v[i]
+	}
+	return nil
+}
+
+func unescapePointerToken(token string) string {
+	return strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+}
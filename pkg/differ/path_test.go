@@ -0,0 +1,102 @@
+package differ
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newDeploymentWithSidecar() *YamlObject {
+	obj := newDeploymentWithLabels("ingester", map[string]string{"name": "ingester"})
+	containers := []interface{}{
+		map[string]interface{}{"name": "sidecar", "image": "busybox"},
+		map[string]interface{}{"name": "ingester", "image": "grafana/mimir:2.0.0", "resources": map[string]interface{}{"limits": map[string]interface{}{"cpu": 1}}},
+	}
+	err := obj.Set("/spec/template/spec/containers", containers)
+	if err != nil {
+		panic(err)
+	}
+	return obj
+}
+
+func TestExpandPath(t *testing.T) {
+	doc, err := newDeploymentWithSidecar().Document()
+	require.NoError(t, err)
+
+	t.Run("wildcards select every element", func(t *testing.T) {
+		expansions, err := expandPath(doc, "/spec/template/spec/containers/*/image")
+		require.NoError(t, err)
+		require.Len(t, expansions, 2)
+		require.Equal(t, "/spec/template/spec/containers/0/image", expansions[0].pointer)
+		require.Equal(t, "/spec/template/spec/containers/1/image", expansions[1].pointer)
+		require.Equal(t, []string{"1"}, expansions[1].bindings)
+	})
+
+	t.Run("key predicates select matching elements", func(t *testing.T) {
+		expansions, err := expandPath(doc, "/spec/template/spec/containers[name=ingester]/image")
+		require.NoError(t, err)
+		require.Len(t, expansions, 1)
+		require.Equal(t, "/spec/template/spec/containers/1/image", expansions[0].pointer)
+	})
+
+	t.Run("invalid predicates are rejected", func(t *testing.T) {
+		_, err := expandPath(doc, "/spec/template/spec/containers[name]/image")
+		require.Error(t, err)
+	})
+
+	t.Run("targets are derived from the source bindings", func(t *testing.T) {
+		path, err := substitutePath("/spec/template/spec/containers/*/img", []string{"1"})
+		require.NoError(t, err)
+		require.Equal(t, "/spec/template/spec/containers/1/img", path)
+	})
+}
+
+func TestExtendedPathsInRules(t *testing.T) {
+	t.Run("steps are applied to every expansion they apply to", func(t *testing.T) {
+		object := newDeploymentWithSidecar()
+		rule := Json6902PatchRule{RemoveField: "/spec/template/spec/containers/*/resources"}
+		rule = Desugar(rule)[0]
+
+		debugInfo := NewDebugInfo(RuleSet{PatchRules: []Json6902PatchRule{rule}}).NewRuleDebugInfo(0, rule)
+		result, err := rule.MapObject(object, debugInfo)
+		require.NoError(t, err)
+
+		_, err = result.Get("/spec/template/spec/containers/1/resources")
+		require.Error(t, err, "resources should have been removed")
+		require.NoError(t, debugInfo.ValidateAllStepsWereEffective())
+		require.Equal(t, []string{"/spec/template/spec/containers/1/resources"}, debugInfo.Matches[0].Paths())
+		require.Equal(t, []string{"/spec/template/spec/containers/1/resources"}, debugInfo.Patches[0].Paths())
+	})
+
+	t.Run("key predicates can be used in matches", func(t *testing.T) {
+		object := newDeploymentWithSidecar()
+		match := Json6902Patch{
+			{Op: "test", Path: "/spec/template/spec/containers[name=ingester]/image", Value: "grafana/mimir:2.0.0"},
+		}
+		ok, err := match.Matches(object, nil)
+		require.NoError(t, err)
+		require.True(t, ok)
+
+		match[0].Value = "busybox"
+		ok, err = match.Matches(object, nil)
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+
+	t.Run("renames move each expansion of the source", func(t *testing.T) {
+		object := newDeploymentWithSidecar()
+		rule := Desugar(Json6902PatchRule{RenameField: &RenameRule{
+			From: "/spec/template/spec/containers/*/image",
+			To:   "/spec/template/spec/containers/*/img",
+		}})[0]
+		result, err := rule.MapObject(object, nil)
+		require.NoError(t, err)
+
+		image, err := result.Get("/spec/template/spec/containers/0/img")
+		require.NoError(t, err)
+		require.Equal(t, "busybox", image)
+		image, err = result.Get("/spec/template/spec/containers/1/img")
+		require.NoError(t, err)
+		require.Equal(t, "grafana/mimir:2.0.0", image)
+	})
+}
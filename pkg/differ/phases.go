@@ -0,0 +1,169 @@
+package differ
+
+import (
+	"fmt"
+)
+
+// Names of the phases the rules outside of phases belong to. Unless
+// phase_order says otherwise, they run in this order, before any other phase.
+const (
+	IgnoreRulesPhase = "ignore_rules"
+	PatchRulesPhase  = "patch_rules"
+	RulesPhase       = "rules"
+)
+
+// Rule is an entry of an ordered list of rules, holding exactly one ignore,
+// patch, script or KRM function rule.
+type Rule struct {
+	Ignore      *IgnoreRule        `yaml:"ignore,omitempty"`
+	Patch       *Json6902PatchRule `yaml:"patch,omitempty"`
+	Script      *ScriptRule        `yaml:"script,omitempty"`
+	KrmFunction *KrmFunctionRule   `yaml:"krm_function,omitempty"`
+}
+
+func (r *Rule) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Rule
+	if err := unmarshal((*plain)(r)); err != nil {
+		return err
+	}
+	if r.kinds() != 1 {
+		return fmt.Errorf("a rule must have exactly one of %s", ruleKinds)
+	}
+	return nil
+}
+
+// ruleKinds lists the keys of Rule for error messages.
+const ruleKinds = "ignore, patch, script or krm_function"
+
+// kinds returns how many kinds of rule r holds.
+func (r Rule) kinds() int {
+	var n int
+	for _, set := range []bool{r.Ignore != nil, r.Patch != nil, r.Script != nil, r.KrmFunction != nil} {
+		if set {
+			n++
+		}
+	}
+	return n
+}
+
+// ObjectRule returns the rule held by r.
+func (r Rule) ObjectRule() ObjectRule {
+	switch {
+	case r.Ignore != nil:
+		return *r.Ignore
+	case r.Script != nil:
+		return *r.Script
+	case r.KrmFunction != nil:
+		return *r.KrmFunction
+	}
+	return *r.Patch
+}
+
+// location returns the fields recording the file and line the rule held by r
+// was loaded from.
+func (r Rule) location() (source *string, line *int) {
+	switch {
+	case r.Ignore != nil:
+		return &r.Ignore.Source, &r.Ignore.Line
+	case r.Script != nil:
+		return &r.Script.Source, &r.Script.Line
+	case r.KrmFunction != nil:
+		return &r.KrmFunction.Source, &r.KrmFunction.Line
+	}
+	return &r.Patch.Source, &r.Patch.Line
+}
+
+// Phase is a named, ordered list of rules. Phases with the same name in
+// several rule files are merged, in the order the files were loaded.
+type Phase struct {
+	Name  string `yaml:"name"`
+	Rules []Rule `yaml:"rules"`
+}
+
+// Phases returns the phases of the rule set in the order they run, including
+// the phases of ignore_rules, patch_rules and rules. Phases listed in
+// phase_order run first, in that order. The remaining phases follow in the
+// order they were first defined.
+func (r *RuleSet) Phases() []Phase {
+	var names []string
+	var phases = map[string]*Phase{}
+	var add = func(name string, rules []Rule) {
+		if len(rules) == 0 {
+			return
+		}
+		if phases[name] == nil {
+			phases[name] = &Phase{Name: name}
+			names = append(names, name)
+		}
+		phases[name].Rules = append(phases[name].Rules, rules...)
+	}
+
+	var ignoreRules, patchRules []Rule
+	for i := range r.IgnoreRules {
+		ignoreRules = append(ignoreRules, Rule{Ignore: &r.IgnoreRules[i]})
+	}
+	for i := range r.PatchRules {
+		patchRules = append(patchRules, Rule{Patch: &r.PatchRules[i]})
+	}
+	add(IgnoreRulesPhase, ignoreRules)
+	add(PatchRulesPhase, patchRules)
+	add(RulesPhase, r.Rules)
+	for _, phase := range r.NamedPhases {
+		add(phase.Name, phase.Rules)
+	}
+
+	var result []Phase
+	var done = map[string]bool{}
+	for _, name := range append(append([]string{}, r.PhaseOrder...), names...) {
+		if done[name] || phases[name] == nil {
+			continue
+		}
+		done[name] = true
+		result = append(result, *phases[name])
+	}
+	return result
+}
+
+// ValidatePhaseOrder fails if phase_order refers to a phase which is neither
+// defined by a rule file nor one of the phases of ignore_rules, patch_rules
+// and rules, e.g. because of a typo. Call it once all rule files are merged,
+// since phases may be defined in other files than the one ordering them.
+func (r *RuleSet) ValidatePhaseOrder() error {
+	known := map[string]bool{IgnoreRulesPhase: true, PatchRulesPhase: true, RulesPhase: true}
+	for _, phase := range r.NamedPhases {
+		known[phase.Name] = true
+	}
+	for _, name := range r.PhaseOrder {
+		if !known[name] {
+			return fmt.Errorf("phase_order refers to unknown phase %q", name)
+		}
+	}
+	return nil
+}
+
+// ObjectRules returns all rules of the rule set in the order they run.
+func (r *RuleSet) ObjectRules() []ObjectRule {
+	var result []ObjectRule
+	for _, phase := range r.Phases() {
+		for _, rule := range phase.Rules {
+			result = append(result, rule.ObjectRule())
+		}
+	}
+	return result
+}
+
+// mergePhaseOrder appends the phases of other which aren't already in order,
+// so that every rule file can order the phases it knows about.
+func mergePhaseOrder(order, other []string) []string {
+	seen := map[string]bool{}
+	for _, name := range order {
+		seen[name] = true
+	}
+	for _, name := range other {
+		if !seen[name] {
+			seen[name] = true
+			order = append(order, name)
+		}
+	}
+	return order
+}
@@ -0,0 +1,122 @@
+package differ
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestPhases(t *testing.T) {
+	loadRuleSets := func(t *testing.T, files ...string) RuleSet {
+		var ruleSet RuleSet
+		for _, file := range files {
+			var subRules RuleSet
+			require.NoError(t, yaml.Unmarshal([]byte(file), &subRules))
+			ruleSet.Merge(&subRules)
+		}
+		ruleSet.Desugar()
+		return ruleSet
+	}
+
+	ruleNames := func(ruleSet RuleSet) []string {
+		var names []string
+		for _, rule := range ruleSet.ObjectRules() {
+			names = append(names, rule.Describe().Name)
+		}
+		return names
+	}
+
+	t.Run("ignore rules run before patch rules", func(t *testing.T) {
+		ruleSet := loadRuleSets(t, `
+patch_rules:
+- name: patch
+ignore_rules:
+- name: ignore
+`)
+		require.Equal(t, []string{"ignore", "patch"}, ruleNames(ruleSet))
+	})
+
+	t.Run("rules run in the order they are listed", func(t *testing.T) {
+		ruleSet := loadRuleSets(t, `
+rules:
+- patch:
+    rename_object: {from: mimir-querier, to: querier}
+- ignore:
+    name: ignore querier
+    match:
+    - {op: test, path: /metadata/name, value: querier}
+`)
+		require.Equal(t, []string{"Rename mimir-querier to querier", "ignore querier"}, ruleNames(ruleSet))
+
+		objects, err := ApplyRuleSet([]*YamlObject{newDeploymentWithLabels("mimir-querier", nil)}, ruleSet, nil)
+		require.NoError(t, err)
+		require.Empty(t, objects, "the ignore rule matches the renamed object")
+	})
+
+	t.Run("phases are merged across files and ordered by phase_order", func(t *testing.T) {
+		ruleSet := loadRuleSets(t, `
+phase_order: [renames, ignore_rules]
+phases:
+- name: cleanup
+  rules:
+  - patch: {name: cleanup a}
+- name: renames
+  rules:
+  - patch: {name: rename a}
+ignore_rules:
+- name: ignore a
+`, `
+phase_order: [ignore_rules, cleanup]
+phases:
+- name: renames
+  rules:
+  - patch: {name: rename b}
+patch_rules:
+- name: patch b
+`)
+		require.Equal(t, []string{"rename a", "rename b", "ignore a", "cleanup a", "patch b"}, ruleNames(ruleSet))
+	})
+
+	t.Run("phase_order must refer to known phases", func(t *testing.T) {
+		ruleSet := loadRuleSets(t, `
+phase_order: [renames, ignore_rules]
+`, `
+phases:
+- name: renames
+  rules:
+  - patch: {name: rename a}
+`)
+		require.NoError(t, ruleSet.ValidatePhaseOrder(), "phases can be defined in other files")
+
+		ruleSet = loadRuleSets(t, `
+phase_order: [renmaes]
+phases:
+- name: renames
+  rules:
+  - patch: {name: rename a}
+`)
+		require.EqualError(t, ruleSet.ValidatePhaseOrder(), `phase_order refers to unknown phase "renmaes"`)
+	})
+
+	t.Run("matchers in phases are desugared", func(t *testing.T) {
+		ruleSet := loadRuleSets(t, `
+phases:
+- name: cleanup
+  rules:
+  - patch:
+      remove_field: /spec/replicas
+      /kind: [Deployment, StatefulSet]
+`)
+		require.Len(t, ruleSet.ObjectRules(), 1)
+	})
+
+	t.Run("rules must hold exactly one kind of rule", func(t *testing.T) {
+		var ruleSet RuleSet
+		err := yaml.Unmarshal([]byte(`
+rules:
+- name: neither
+`), &ruleSet)
+		require.Error(t, err)
+	})
+}
@@ -13,29 +13,100 @@ type ObjectRule interface {
 	MapObject(obj *YamlObject, debug *RuleDebugInfo) (*YamlObject, error)
 }
 
+// ObjectSetRule is implemented by rules which need to see all objects of an
+// input at once. MapObjects uses MapObjectSet instead of MapObject for them.
+type ObjectSetRule interface {
+	ObjectRule
+	MapObjectSet(objects []*YamlObject, debug *RuleDebugInfo) ([]*YamlObject, error)
+}
+
 type ObjectRuleDescription struct {
 	Name       string
 	Todo       bool
 	MatchRules Json6902Patch
-	PatchRules Json6902Patch
+	// Conditions describe the conditions evaluated after MatchRules, e.g. on
+	// the other side.
+	Conditions []string
+	// Alternatives are the indices of conditions which only need to hold for
+	// some of the objects passing the previous conditions, such as the values
+	// of a matcher.
+	Alternatives map[int]bool
+	PatchRules   Json6902Patch
+	// Inputs are the names of the inputs the rule applies to, all of them if
+	// empty.
+	Inputs []string
+	// Source is the rule file the rule was loaded from, if any, and Line the
+	// line of the rule in it.
+	Source string
+	Line   int
 }
 
 type RuleSet struct {
+	// Include are other rule files or globs loaded before this file, relative
+	// to it, see RuleLoader.
+	Include []string `yaml:"include,omitempty"`
+
+	// IgnoreRules and PatchRules are sugar for the phases ignore_rules and
+	// patch_rules, see Phases.
 	IgnoreRules []IgnoreRule        `yaml:"ignore_rules"`
 	PatchRules  []Json6902PatchRule `yaml:"patch_rules"`
+
+	// Rules is an ordered list mixing ignore and patch rules, run as the phase
+	// named rules.
+	Rules       []Rule   `yaml:"rules,omitempty"`
+	NamedPhases []Phase  `yaml:"phases,omitempty"`
+	PhaseOrder  []string `yaml:"phase_order,omitempty"`
+
+	// ReferencePaths extend DefaultReferencePaths for renames with
+	// update_references.
+	ReferencePaths []ReferencePath `yaml:"reference_paths,omitempty"`
 }
 
 func (r *RuleSet) Merge(other *RuleSet) {
 	r.IgnoreRules = append(r.IgnoreRules, other.IgnoreRules...)
 	r.PatchRules = append(r.PatchRules, other.PatchRules...)
+	r.Rules = append(r.Rules, other.Rules...)
+	r.NamedPhases = append(r.NamedPhases, other.NamedPhases...)
+	r.PhaseOrder = mergePhaseOrder(r.PhaseOrder, other.PhaseOrder)
+	r.ReferencePaths = append(r.ReferencePaths, other.ReferencePaths...)
 }
 
 func (r *RuleSet) Desugar() {
 	finalRules := make([]Json6902PatchRule, 0, len(r.PatchRules))
 	for i := range r.PatchRules {
-		finalRules = append(finalRules, Desugar(r.PatchRules[i])...)
+		finalRules = append(finalRules, r.desugarPatchRule(r.PatchRules[i])...)
 	}
 	r.PatchRules = finalRules
+
+	r.Rules = r.desugarRules(r.Rules)
+	for i := range r.NamedPhases {
+		r.NamedPhases[i].Rules = r.desugarRules(r.NamedPhases[i].Rules)
+	}
+}
+
+func (r *RuleSet) desugarRules(rules []Rule) []Rule {
+	var finalRules []Rule
+	for _, rule := range rules {
+		if rule.Patch == nil {
+			finalRules = append(finalRules, rule)
+			continue
+		}
+		for _, patch := range r.desugarPatchRule(*rule.Patch) {
+			patch := patch
+			finalRules = append(finalRules, Rule{Patch: &patch})
+		}
+	}
+	return finalRules
+}
+
+func (r *RuleSet) desugarPatchRule(rule Json6902PatchRule) []Json6902PatchRule {
+	finalRules := Desugar(rule)
+	for i := range finalRules {
+		if finalRules[i].References != nil {
+			finalRules[i].References = append(finalRules[i].References, r.ReferencePaths...)
+		}
+	}
+	return finalRules
 }
 
 type Json6902PatchRule struct {
@@ -43,30 +114,121 @@ type Json6902PatchRule struct {
 	Match Json6902Patch `yaml:"match,omitempty"`
 	Steps Json6902Patch `yaml:"steps,omitempty"`
 
+	// When is a CEL expression which must hold for the object, in addition
+	// to Match.
+	When string `yaml:"when,omitempty"`
+
+	// Target selects the objects of the rule like the target of kustomize
+	// patches, in addition to Match.
+	Target *Target `yaml:"target,omitempty"`
+
 	Todo bool `yaml:"todo,omitempty"`
 
+	// Inputs restricts the rule to the named inputs.
+	Inputs []string `yaml:"inputs,omitempty"`
+
+	// Source is the rule file the rule was loaded from, and Line the line of
+	// the rule in it.
+	Source string `yaml:"-"`
+	Line   int    `yaml:"-"`
+
 	// These fields exist to support syntax sugar.
 	// They are converted to the above fields when the rule is created.
-	RemoveField  string                   `yaml:"remove_field,omitempty"`
-	RenameField  *RenameRule              `yaml:"rename_field,omitempty"`
-	RenameObject *RenameRule              `yaml:"rename_object,omitempty"`
-	Matchers     map[string][]interface{} `yaml:",inline"`
+	RemoveField    string                   `yaml:"remove_field,omitempty"`
+	RenameField    *RenameRule              `yaml:"rename_field,omitempty"`
+	RenameObject   *RenameRule              `yaml:"rename_object,omitempty"`
+	SetField       *FieldValue              `yaml:"set_field,omitempty"`
+	CopyField      *FieldCopy               `yaml:"copy_field,omitempty"`
+	RemoveFields   []string                 `yaml:"remove_fields,omitempty"`
+	AddIfMissing   *FieldValue              `yaml:"add_if_missing,omitempty"`
+	RemoveIfEquals *FieldValue              `yaml:"remove_if_equals,omitempty"`
+	Matchers       map[string][]interface{} `yaml:",inline"`
+
+	// MatchEachValue requires every value of the matchers to match at least
+	// one object for the rule to be effective, not only the rule as a whole.
+	MatchEachValue bool `yaml:"match_each_value,omitempty"`
+
+	// matchers are the matchers with several values, set by Desugar.
+	matchers []matcher
+
+	OtherSideCondition `yaml:",inline"`
+
+	// References are the reference paths updated when the rule renames an
+	// object. They are set by desugaring rename_object with update_references.
+	References []ReferencePath `yaml:"-"`
+
+	context *RuleContext
 }
 
 type RenameRule struct {
 	From string `yaml:"from"`
 	To   string `yaml:"to"`
+
+	// Only supported by rename_object. Regex treats From as a regular
+	// expression and To as a template which may refer to its capture groups.
+	// Kind restricts the rename to objects of that kind.
+	Regex bool   `yaml:"regex,omitempty"`
+	Kind  string `yaml:"kind,omitempty"`
+
+	// Only supported by rename_object. UpdateReferences renames all
+	// references to a renamed object in the other objects of the same input.
+	UpdateReferences bool `yaml:"update_references,omitempty"`
+}
+
+// FieldValue is a field and a value, for set_field, add_if_missing and
+// remove_if_equals.
+type FieldValue struct {
+	Path  string      `yaml:"path"`
+	Value interface{} `yaml:"value"`
+}
+
+// FieldCopy is a field to copy and its destination, for copy_field.
+type FieldCopy struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
 }
 
 func (j Json6902PatchRule) Describe() ObjectRuleDescription {
+	// The conditions of a matcher are its values, if each must match, and the
+	// matcher itself.
+	conditions := j.conditions()
+	alternatives := map[int]bool{}
+	for _, m := range j.matchers {
+		described := m.describe(j.MatchEachValue)
+		for i := 0; i < len(described)-1; i++ {
+			alternatives[len(conditions)+i] = true
+		}
+		conditions = append(conditions, described...)
+	}
 	return ObjectRuleDescription{
-		Name:       j.Name,
-		Todo:       j.Todo,
-		MatchRules: j.Match,
-		PatchRules: j.Steps,
+		Name:         j.Name,
+		Todo:         j.Todo,
+		MatchRules:   j.Match,
+		Conditions:   conditions,
+		Alternatives: alternatives,
+		PatchRules:   j.Steps,
+		Inputs:       j.Inputs,
+		Source:       j.Source,
+		Line:         j.Line,
 	}
 }
 
+// conditions describes the other side condition, when and target of the
+// rule, in the order they are evaluated, before the matchers.
+func (j Json6902PatchRule) conditions() []string {
+	conditions := append(j.OtherSideCondition.Describe(), whenDescription(j.When)...)
+	return append(conditions, targetDescription(j.Target)...)
+}
+
+func (j Json6902PatchRule) NeedsOtherSide() bool {
+	return j.OtherSideCondition.IsSet()
+}
+
+func (j Json6902PatchRule) WithContext(ctx *RuleContext) ObjectRule {
+	j.context = ctx
+	return j
+}
+
 func (j Json6902PatchRule) MapObject(obj *YamlObject, debug *RuleDebugInfo) (*YamlObject, error) {
 	ok, err := j.Match.Matches(obj, debug)
 	if err != nil {
@@ -79,6 +241,38 @@ func (j Json6902PatchRule) MapObject(obj *YamlObject, debug *RuleDebugInfo) (*Ya
 		return obj, nil
 	}
 
+	ok, err = j.OtherSideCondition.Matches(obj, j.context, debug)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return obj, nil
+	}
+
+	ok, err = matchesWhen(j.When, obj, len(j.OtherSideCondition.Describe()), debug)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return obj, nil
+	}
+
+	ok, err = matchesTarget(j.Target, obj, len(j.OtherSideCondition.Describe())+len(whenDescription(j.When)), debug)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return obj, nil
+	}
+
+	condition := len(j.conditions())
+	for _, m := range j.matchers {
+		if !m.matches(obj, j.MatchEachValue, condition, debug) {
+			return obj, nil
+		}
+		condition += len(m.describe(j.MatchEachValue))
+	}
+
 	err = j.Steps.ApplyToObject(obj, debug)
 	if err != nil {
 		return nil, err
@@ -87,12 +281,44 @@ func (j Json6902PatchRule) MapObject(obj *YamlObject, debug *RuleDebugInfo) (*Ya
 	return obj, nil
 }
 
+// MapObjectSet maps every object like MapObject. If the rule renames objects
+// and has References, references to the renamed objects are updated in all
+// objects afterwards.
+func (j Json6902PatchRule) MapObjectSet(objects []*YamlObject, debug *RuleDebugInfo) ([]*YamlObject, error) {
+	var renames []objectRename
+	result := []*YamlObject{}
+	for _, obj := range objects {
+		before := ObjectIdentityForObject(obj)
+		mapped, err := j.MapObject(obj, debug)
+		if err != nil {
+			return nil, err
+		}
+		if mapped == nil {
+			continue
+		}
+		if after := ObjectIdentityForObject(mapped); after.Name != before.Name {
+			renames = append(renames, objectRename{from: before, to: after.Name})
+		}
+		result = append(result, mapped)
+	}
+
+	if len(j.References) == 0 {
+		return result, nil
+	}
+	for _, rename := range renames {
+		if err := updateReferences(result, rename, j.References, debug); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
 type Json6902Patch []Json6902Operation
 
 func (j Json6902Patch) Matches(obj *YamlObject, debug *RuleDebugInfo) (bool, error) {
 	obj = obj.DeepCopy()
 	for i, step := range j {
-		matches, err := step.Matches(obj)
+		matches, paths, err := step.matchConcrete(obj)
 		if err != nil {
 			return false, err
 		}
@@ -100,6 +326,7 @@ func (j Json6902Patch) Matches(obj *YamlObject, debug *RuleDebugInfo) (bool, err
 			return false, nil
 		}
 		debug.RecordIncrementalMatch(i, obj)
+		debug.RecordIncrementalMatchPaths(i, paths)
 	}
 	return true, nil
 }
@@ -107,20 +334,21 @@ func (j Json6902Patch) Matches(obj *YamlObject, debug *RuleDebugInfo) (bool, err
 func (j Json6902Patch) ApplyToObject(obj *YamlObject, debug *RuleDebugInfo) error {
 	for i, step := range j {
 		originalObj := obj.DeepCopy()
-		err := step.ApplyToObject(obj)
+		paths, err := step.applyConcrete(obj)
 		if err != nil {
 			return err
 		}
 		debug.RecordIncrementalPatch(i, originalObj, obj)
+		debug.RecordIncrementalPatchPaths(i, paths)
 	}
 	return nil
 }
 
 type Json6902Operation struct {
-	Op    string      `yaml:"op" json:"op"`                 // Required for all
-	Path  string      `yaml:"path" json:"path"`             // Required for all
-	From  string      `yaml:"from" json:"from,omitempty"`   // Required for copy / move
-	Value interface{} `yaml:"value" json:"value,omitempty"` // Required for add / replace / test
+	Op    string      `yaml:"op" json:"op"`                           // Required for all
+	Path  string      `yaml:"path" json:"path"`                       // Required for all
+	From  string      `yaml:"from,omitempty" json:"from,omitempty"`   // Required for copy / move
+	Value interface{} `yaml:"value,omitempty" json:"value,omitempty"` // Required for add / replace / test
 }
 
 func (j Json6902Operation) String() string {
@@ -137,39 +365,196 @@ func (j Json6902Operation) String() string {
 		return "copy " + j.Path + " from " + j.From
 	case "test":
 		return "replace " + j.Path + ": " + fmt.Sprint(j.Value)
+	case "substitute":
+		return "substitute " + j.Path + ": " + fmt.Sprint(j.Value)
+	case "test_exists", "test_absent":
+		return j.Op + " " + j.Path
 	default:
+		if IsMatchOp(j.Op) {
+			return j.Op + " " + j.Path + ": " + fmt.Sprint(j.Value)
+		}
 		return j.Op
 	}
 }
 
 func (j Json6902Operation) Matches(obj *YamlObject) (bool, error) {
+	matches, _, err := j.matchConcrete(obj)
+	return matches, err
+}
+
+// matchConcrete tests the operation against the object and returns the
+// concrete paths it could be applied to. An operation with an extended path
+// matches if it can be applied to at least one of its expansions.
+func (j Json6902Operation) matchConcrete(obj *YamlObject) (bool, []string, error) {
+	if IsMatchOp(j.Op) {
+		return j.matchValue(obj)
+	}
+
+	ops, err := j.expand(obj)
+	if err != nil {
+		return false, nil, err
+	}
+
 	buf := new(bytes.Buffer)
-	err := EncodeYamlObjectAsJson(buf, obj)
+	err = EncodeYamlObjectAsJson(buf, obj)
 	if err != nil {
-		return false, err
+		return false, nil, err
 	}
 
-	_, err = j.Apply(buf.Bytes())
-	return err == nil, nil
+	var paths []string
+	for _, op := range ops {
+		if _, err := op.Apply(buf.Bytes()); err == nil {
+			paths = append(paths, op.Path)
+		}
+	}
+	return len(paths) > 0, paths, nil
 }
 
 func (j Json6902Operation) ApplyToObject(obj *YamlObject) error {
-	buf := new(bytes.Buffer)
-	err := EncodeYamlObjectAsJson(buf, obj)
+	_, err := j.applyConcrete(obj)
+	return err
+}
+
+// applyConcrete applies the operation to the object and returns the concrete
+// paths it was applied to. An operation with an extended path is applied to
+// each of its expansions it can be applied to, and fails only if there is
+// none.
+func (j Json6902Operation) applyConcrete(obj *YamlObject) ([]string, error) {
+	if IsMatchOp(j.Op) {
+		return nil, fmt.Errorf("%s: %s can only be used to match objects", j, j.Op)
+	}
+
+	var ops []Json6902Operation
+	var err error
+	if j.Op == "substitute" {
+		ops, err = j.substitute(obj)
+	} else {
+		ops, err = j.expand(obj)
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	objBuf, err := j.Apply(buf.Bytes())
+	buf := new(bytes.Buffer)
+	err = EncodeYamlObjectAsJson(buf, obj)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	objBuf := buf.Bytes()
+	var paths []string
+	for _, op := range ops {
+		patched, applyErr := op.Apply(objBuf)
+		if applyErr != nil {
+			err = applyErr
+			continue
+		}
+		objBuf = patched
+		paths = append(paths, op.Path)
+	}
+	if len(paths) == 0 {
+		if err == nil {
+			err = fmt.Errorf("%s: path does not select anything", j)
+		}
+		return nil, err
 	}
 
 	// Decode the json back into an object. We have to clear the object first
 	// otherwise field removal at the root will not work.
 	obj.Object = make(map[string]interface{})
 	err = DecodeYamlObject(bytes.NewReader(objBuf), obj)
-	return err
+	return paths, err
+}
+
+// substitute converts a substitute operation into replace operations. Its
+// value is a map with a regular expression "pattern" and a "replacement"
+// template, which are applied to the string at each expansion of its path.
+// Strings not matching the pattern are left as is.
+func (j Json6902Operation) substitute(obj *YamlObject) ([]Json6902Operation, error) {
+	value, err := normalizeValue(j.Value)
+	if err != nil {
+		return nil, err
+	}
+	params, _ := value.(map[string]interface{})
+	pattern, ok := params["pattern"].(string)
+	if !ok {
+		return nil, fmt.Errorf("%s: value must have a pattern", j)
+	}
+	replacement, _ := params["replacement"].(string)
+	re, err := compileRegexp(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", j, err)
+	}
+
+	doc, err := obj.Document()
+	if err != nil {
+		return nil, err
+	}
+	expansions, err := expandPath(doc, j.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var ops []Json6902Operation
+	for _, e := range expansions {
+		current, ok := lookup(doc, e.pointer).(string)
+		if !ok || !re.MatchString(current) {
+			continue
+		}
+		ops = append(ops, Json6902Operation{
+			Op:    "replace",
+			Path:  e.pointer,
+			Value: re.ReplaceAllString(current, replacement),
+		})
+	}
+	return ops, nil
+}
+
+// expand returns one concrete operation per location selected by the
+// extended path of the operation, see IsExtendedPath. If the source of a move
+// or copy is extended, the target is derived from each expansion of the
+// source. Expansions are returned in reverse order so that removing list
+// elements doesn't shift the indices of the remaining ones.
+func (j Json6902Operation) expand(obj *YamlObject) ([]Json6902Operation, error) {
+	if !IsExtendedPath(j.Path) && !IsExtendedPath(j.From) {
+		return []Json6902Operation{j}, nil
+	}
+
+	doc, err := obj.Document()
+	if err != nil {
+		return nil, err
+	}
+
+	var ops []Json6902Operation
+	if IsExtendedPath(j.From) {
+		expansions, err := expandPath(doc, j.From)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range expansions {
+			op := j
+			op.From = e.pointer
+			if op.Path, err = substitutePath(j.Path, e.bindings); err != nil {
+				return nil, err
+			}
+			ops = append(ops, op)
+		}
+	} else {
+		expansions, err := expandPath(doc, j.Path)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range expansions {
+			op := j
+			op.Path = e.pointer
+			ops = append(ops, op)
+		}
+	}
+
+	for i, k := 0, len(ops)-1; i < k; i, k = i+1, k-1 {
+		ops[i], ops[k] = ops[k], ops[i]
+	}
+	return ops, nil
 }
 
 func (j Json6902Operation) Apply(buf []byte) ([]byte, error) {
@@ -191,7 +576,27 @@ type IgnoreRule struct {
 	Match Json6902Patch `yaml:"match"`
 	Name  string        `yaml:"name"`
 
+	// When is a CEL expression which must hold for the object, in addition
+	// to Match.
+	When string `yaml:"when,omitempty"`
+
+	// Target selects the objects of the rule like the target of kustomize
+	// patches, in addition to Match.
+	Target *Target `yaml:"target,omitempty"`
+
 	Todo bool `yaml:"todo"`
+
+	// Inputs restricts the rule to the named inputs.
+	Inputs []string `yaml:"inputs,omitempty"`
+
+	// Source is the rule file the rule was loaded from, and Line the line of
+	// the rule in it.
+	Source string `yaml:"-"`
+	Line   int    `yaml:"-"`
+
+	OtherSideCondition `yaml:",inline"`
+
+	context *RuleContext
 }
 
 func (e IgnoreRule) Describe() ObjectRuleDescription {
@@ -199,10 +604,30 @@ func (e IgnoreRule) Describe() ObjectRuleDescription {
 		Name:       e.Name,
 		Todo:       e.Todo,
 		MatchRules: e.Match,
+		Conditions: e.conditions(),
 		PatchRules: nil,
+		Inputs:     e.Inputs,
+		Source:     e.Source,
+		Line:       e.Line,
 	}
 }
 
+// conditions describes the other side condition, when and target of the
+// rule, in the order they are evaluated.
+func (e IgnoreRule) conditions() []string {
+	conditions := append(e.OtherSideCondition.Describe(), whenDescription(e.When)...)
+	return append(conditions, targetDescription(e.Target)...)
+}
+
+func (e IgnoreRule) NeedsOtherSide() bool {
+	return e.OtherSideCondition.IsSet()
+}
+
+func (e IgnoreRule) WithContext(ctx *RuleContext) ObjectRule {
+	e.context = ctx
+	return e
+}
+
 func (e IgnoreRule) MapObject(obj *YamlObject, debug *RuleDebugInfo) (*YamlObject, error) {
 	ok, err := e.Match.Matches(obj, debug)
 	if err != nil {
@@ -214,6 +639,30 @@ func (e IgnoreRule) MapObject(obj *YamlObject, debug *RuleDebugInfo) (*YamlObjec
 	if !ok {
 		return obj, nil
 	}
+
+	ok, err = e.OtherSideCondition.Matches(obj, e.context, debug)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return obj, nil
+	}
+
+	ok, err = matchesWhen(e.When, obj, len(e.OtherSideCondition.Describe()), debug)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return obj, nil
+	}
+
+	ok, err = matchesTarget(e.Target, obj, len(e.OtherSideCondition.Describe())+len(whenDescription(e.When)), debug)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return obj, nil
+	}
 	debug.RecordIgnore(obj)
 	return nil, nil
 }
@@ -0,0 +1,135 @@
+package differ
+
+import "strings"
+
+// ReferencePath is a field of one object which refers to another object by
+// name. Paths may use the extended path syntax, see IsExtendedPath.
+type ReferencePath struct {
+	// Kind is the kind of the object referred to.
+	Kind string `yaml:"kind"`
+	// ReferrerKind is the kind of the object containing the reference. An
+	// empty ReferrerKind matches objects of any kind.
+	ReferrerKind string `yaml:"referrer_kind,omitempty"`
+	Path         string `yaml:"path"`
+	// NamespaceField is the field next to the reference holding the namespace
+	// of the object referred to, e.g. namespace in the subjects of
+	// RoleBindings. Without it, or if the field is absent, the object referred
+	// to is in the namespace of the referrer.
+	NamespaceField string `yaml:"namespace_field,omitempty"`
+}
+
+// DefaultReferencePaths are the known references between core and apps
+// objects. Rule files can extend them with reference_paths, e.g. for CRDs.
+var DefaultReferencePaths = defaultReferencePaths()
+
+func defaultReferencePaths() []ReferencePath {
+	var podSpecs = map[string]string{
+		"Pod":         "/spec",
+		"Deployment":  "/spec/template/spec",
+		"StatefulSet": "/spec/template/spec",
+		"DaemonSet":   "/spec/template/spec",
+		"ReplicaSet":  "/spec/template/spec",
+		"Job":         "/spec/template/spec",
+		"CronJob":     "/spec/jobTemplate/spec/template/spec",
+	}
+	var podSpecReferences = []ReferencePath{
+		{Kind: "ConfigMap", Path: "/volumes/*/configMap/name"},
+		{Kind: "ConfigMap", Path: "/volumes/*/projected/sources/*/configMap/name"},
+		{Kind: "ConfigMap", Path: "/containers/*/envFrom/*/configMapRef/name"},
+		{Kind: "ConfigMap", Path: "/containers/*/env/*/valueFrom/configMapKeyRef/name"},
+		{Kind: "ConfigMap", Path: "/initContainers/*/envFrom/*/configMapRef/name"},
+		{Kind: "ConfigMap", Path: "/initContainers/*/env/*/valueFrom/configMapKeyRef/name"},
+		{Kind: "Secret", Path: "/volumes/*/secret/secretName"},
+		{Kind: "Secret", Path: "/volumes/*/projected/sources/*/secret/name"},
+		{Kind: "Secret", Path: "/containers/*/envFrom/*/secretRef/name"},
+		{Kind: "Secret", Path: "/containers/*/env/*/valueFrom/secretKeyRef/name"},
+		{Kind: "Secret", Path: "/initContainers/*/envFrom/*/secretRef/name"},
+		{Kind: "Secret", Path: "/initContainers/*/env/*/valueFrom/secretKeyRef/name"},
+		{Kind: "Secret", Path: "/imagePullSecrets/*/name"},
+		{Kind: "PersistentVolumeClaim", Path: "/volumes/*/persistentVolumeClaim/claimName"},
+		{Kind: "ServiceAccount", Path: "/serviceAccountName"},
+	}
+
+	var result []ReferencePath
+	for _, referrerKind := range []string{"Pod", "Deployment", "StatefulSet", "DaemonSet", "ReplicaSet", "Job", "CronJob"} {
+		for _, ref := range podSpecReferences {
+			result = append(result, ReferencePath{
+				Kind:         ref.Kind,
+				ReferrerKind: referrerKind,
+				Path:         podSpecs[referrerKind] + ref.Path,
+			})
+		}
+	}
+
+	return append(result,
+		ReferencePath{Kind: "Service", ReferrerKind: "StatefulSet", Path: "/spec/serviceName"},
+		ReferencePath{Kind: "Service", ReferrerKind: "Ingress", Path: "/spec/defaultBackend/service/name"},
+		ReferencePath{Kind: "Service", ReferrerKind: "Ingress", Path: "/spec/rules/*/http/paths/*/backend/service/name"},
+		ReferencePath{Kind: "ServiceAccount", ReferrerKind: "RoleBinding", Path: "/subjects[kind=ServiceAccount]/name", NamespaceField: "namespace"},
+		ReferencePath{Kind: "ServiceAccount", ReferrerKind: "ClusterRoleBinding", Path: "/subjects[kind=ServiceAccount]/name", NamespaceField: "namespace"},
+	)
+}
+
+// objectRename records that a rule renamed an object.
+type objectRename struct {
+	from ObjectIdentity
+	to   string
+}
+
+// updateReferences replaces every reference to the old name of a renamed
+// object by its new name. Only references to an object in the namespace of
+// the renamed object are updated: the namespace of a reference is given by
+// its NamespaceField, or else is the namespace of the referrer, unless the
+// referrer is cluster scoped.
+func updateReferences(objects []*YamlObject, rename objectRename, references []ReferencePath, debug *RuleDebugInfo) error {
+	for _, obj := range objects {
+		id := ObjectIdentityForObject(obj)
+		doc, err := obj.Document()
+		if err != nil {
+			return err
+		}
+
+		var patch Json6902Patch
+		for _, ref := range references {
+			if ref.Kind != rename.from.Kind || (ref.ReferrerKind != "" && ref.ReferrerKind != id.Kind) {
+				continue
+			}
+			expansions, err := expandPath(doc, ref.Path)
+			if err != nil {
+				return err
+			}
+			for _, e := range expansions {
+				if name, ok := lookup(doc, e.pointer).(string); !ok || name != rename.from.Name {
+					continue
+				}
+				if namespace, ok := referenceNamespace(doc, e.pointer, ref, id); ok && namespace != rename.from.Namespace {
+					continue
+				}
+				patch = append(patch, Json6902Operation{Op: "replace", Path: e.pointer, Value: rename.to})
+			}
+		}
+		if len(patch) == 0 {
+			continue
+		}
+
+		oldObj := obj.DeepCopy()
+		if err := patch.ApplyToObject(obj, nil); err != nil {
+			return err
+		}
+		debug.RecordReferenceUpdate(oldObj, obj)
+	}
+	return nil
+}
+
+// referenceNamespace returns the namespace of the object a reference refers
+// to. It returns false if the namespace is unknown, for references in cluster
+// scoped objects without a namespace field.
+func referenceNamespace(doc interface{}, pointer string, ref ReferencePath, referrer ObjectIdentity) (string, bool) {
+	if ref.NamespaceField != "" {
+		parent := pointer[:strings.LastIndex(pointer, "/")]
+		if namespace, ok := lookup(doc, parent+"/"+escapePointerToken(ref.NamespaceField)).(string); ok {
+			return namespace, true
+		}
+	}
+	return referrer.Namespace, referrer.Namespace != ""
+}
@@ -0,0 +1,104 @@
+package differ
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateReferences(t *testing.T) {
+	newObjects := func() []*YamlObject {
+		deployment := newDeploymentWithLabels("querier", nil)
+		err := deployment.Set("/spec/template/spec/volumes", []interface{}{
+			map[string]interface{}{"name": "config", "configMap": map[string]interface{}{"name": "mimir-config"}},
+			map[string]interface{}{"name": "runtime", "configMap": map[string]interface{}{"name": "mimir-runtime"}},
+		})
+		require.NoError(t, err)
+
+		scaledObject := &YamlObject{Object: map[string]interface{}{
+			"kind":     "ScaledObject",
+			"metadata": map[string]interface{}{"name": "querier", "namespace": "default"},
+			"spec":     map[string]interface{}{"configMapName": "mimir-config"},
+		}}
+
+		return []*YamlObject{newConfigMap("mimir-config", nil), deployment, scaledObject}
+	}
+
+	t.Run("references to renamed objects are updated", func(t *testing.T) {
+		ruleSet := RuleSet{
+			PatchRules: []Json6902PatchRule{{
+				RenameObject: &RenameRule{From: "mimir-config", To: "config", UpdateReferences: true},
+			}},
+			ReferencePaths: []ReferencePath{
+				{Kind: "ConfigMap", ReferrerKind: "ScaledObject", Path: "/spec/configMapName"},
+			},
+		}
+		ruleSet.Desugar()
+
+		debugInfo := NewDebugInfo(ruleSet)
+		objects := newObjects()
+		debugInfo.AddInitialObjects(objects)
+		objects, err := ApplyRuleSet(objects, ruleSet, debugInfo)
+		require.NoError(t, err)
+		require.NoError(t, debugInfo.ValidateAllRulesWereEffective())
+
+		name, err := objects[1].Get("/spec/template/spec/volumes/0/configMap/name")
+		require.NoError(t, err)
+		require.Equal(t, "config", name)
+		name, err = objects[1].Get("/spec/template/spec/volumes/1/configMap/name")
+		require.NoError(t, err)
+		require.Equal(t, "mimir-runtime", name, "other references are left as is")
+		name, err = objects[2].Get("/spec/configMapName")
+		require.NoError(t, err)
+		require.Equal(t, "config", name, "reference paths can be extended")
+
+		require.Len(t, debugInfo.RuleDebugInfos[0].ReferenceUpdates, 2)
+	})
+
+	t.Run("references are left as is without update_references", func(t *testing.T) {
+		ruleSet := RuleSet{
+			PatchRules: []Json6902PatchRule{{
+				RenameObject: &RenameRule{From: "mimir-config", To: "config"},
+			}},
+		}
+		ruleSet.Desugar()
+
+		objects, err := ApplyRuleSet(newObjects(), ruleSet, nil)
+		require.NoError(t, err)
+		name, err := objects[1].Get("/spec/template/spec/volumes/0/configMap/name")
+		require.NoError(t, err)
+		require.Equal(t, "mimir-config", name)
+	})
+	t.Run("subjects are only updated in the namespace of the renamed service account", func(t *testing.T) {
+		serviceAccount := func(namespace string) *YamlObject {
+			return &YamlObject{Object: map[string]interface{}{
+				"kind":     "ServiceAccount",
+				"metadata": map[string]interface{}{"name": "querier", "namespace": namespace},
+			}}
+		}
+		binding := &YamlObject{Object: map[string]interface{}{
+			"kind":     "ClusterRoleBinding",
+			"metadata": map[string]interface{}{"name": "querier"},
+			"subjects": []interface{}{
+				map[string]interface{}{"kind": "ServiceAccount", "name": "querier", "namespace": "mimir"},
+				map[string]interface{}{"kind": "ServiceAccount", "name": "querier", "namespace": "loki"},
+			},
+		}}
+		ruleSet := RuleSet{
+			PatchRules: []Json6902PatchRule{{
+				Match:        Json6902Patch{{Op: "test", Path: "/metadata/namespace", Value: "mimir"}},
+				RenameObject: &RenameRule{From: "querier", To: "mimir-querier", Kind: "ServiceAccount", UpdateReferences: true},
+			}},
+		}
+		ruleSet.Desugar()
+
+		objects, err := ApplyRuleSet([]*YamlObject{serviceAccount("mimir"), serviceAccount("loki"), binding}, ruleSet, nil)
+		require.NoError(t, err)
+		name, err := objects[2].Get("/subjects/0/name")
+		require.NoError(t, err)
+		require.Equal(t, "mimir-querier", name)
+		name, err = objects[2].Get("/subjects/1/name")
+		require.NoError(t, err)
+		require.Equal(t, "querier", name, "service accounts of other namespaces are left as is")
+	})
+}
@@ -0,0 +1,256 @@
+package differ
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// RenameSuggestion proposes that the object From on the left side is the same
+// object as To on the right side.
+type RenameSuggestion struct {
+	From       ObjectIdentity
+	To         ObjectIdentity
+	Confidence float64
+}
+
+// renameFeatures are weighted by how much they say about two objects being
+// the same component. The name is a weak signal, since renames are exactly
+// what we are looking for.
+var renameFeatures = []struct {
+	name    string
+	weight  float64
+	extract func(doc interface{}) map[string]bool
+}{
+	{"labels", 2, labelFeatures},
+	{"images", 3, imageFeatures},
+	{"args", 3, argFeatures},
+	{"spec", 1, specFeatures},
+	{"name", 1, nameFeatures},
+}
+
+// DetectRenames scores the similarity of every object only present on the
+// left side with every object of the same kind and namespace only present on
+// the right side. Pairs are then assigned greedily by descending score. Only
+// pairs with a confidence of at least threshold are returned.
+func DetectRenames(diffs []ObjectDiff, threshold float64) ([]RenameSuggestion, error) {
+	type candidate struct {
+		identity ObjectIdentity
+		features []map[string]bool
+	}
+
+	var groupKey = func(id ObjectIdentity) string {
+		return id.Kind + "/" + id.Namespace
+	}
+
+	var extract = func(obj *YamlObject) (candidate, error) {
+		doc, err := obj.Document()
+		if err != nil {
+			return candidate{}, err
+		}
+		c := candidate{identity: ObjectIdentityForObject(obj)}
+		for _, f := range renameFeatures {
+			c.features = append(c.features, f.extract(doc))
+		}
+		return c, nil
+	}
+
+	var lefts, rights = map[string][]candidate{}, map[string][]candidate{}
+	for _, od := range diffs {
+		switch {
+		case od.Right == nil:
+			c, err := extract(od.Left)
+			if err != nil {
+				return nil, err
+			}
+			lefts[groupKey(od.Identity)] = append(lefts[groupKey(od.Identity)], c)
+		case od.Left == nil:
+			c, err := extract(od.Right)
+			if err != nil {
+				return nil, err
+			}
+			rights[groupKey(od.Identity)] = append(rights[groupKey(od.Identity)], c)
+		}
+	}
+
+	var scored []RenameSuggestion
+	for key, leftCandidates := range lefts {
+		for _, l := range leftCandidates {
+			for _, r := range rights[key] {
+				scored = append(scored, RenameSuggestion{
+					From:       l.identity,
+					To:         r.identity,
+					Confidence: similarity(l.features, r.features),
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(scored, func(i, j int) bool {
+		if scored[i].Confidence != scored[j].Confidence {
+			return scored[i].Confidence > scored[j].Confidence
+		}
+		if scored[i].From.String() != scored[j].From.String() {
+			return scored[i].From.String() < scored[j].From.String()
+		}
+		return scored[i].To.String() < scored[j].To.String()
+	})
+
+	var result []RenameSuggestion
+	var usedFrom, usedTo = map[ObjectIdentity]bool{}, map[ObjectIdentity]bool{}
+	for _, s := range scored {
+		if s.Confidence < threshold || usedFrom[s.From] || usedTo[s.To] {
+			continue
+		}
+		usedFrom[s.From] = true
+		usedTo[s.To] = true
+		result = append(result, s)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].From.String() < result[j].From.String()
+	})
+	return result, nil
+}
+
+// similarity is the weighted mean of the Jaccard index of each feature. A
+// feature that is empty on both sides says nothing and is left out.
+func similarity(left, right []map[string]bool) float64 {
+	var total, weights float64
+	for i, f := range renameFeatures {
+		if len(left[i]) == 0 && len(right[i]) == 0 {
+			continue
+		}
+		total += f.weight * jaccard(left[i], right[i])
+		weights += f.weight
+	}
+	if weights == 0 {
+		return 0
+	}
+	return total / weights
+}
+
+func jaccard(a, b map[string]bool) float64 {
+	var intersection int
+	for k := range a {
+		if b[k] {
+			intersection++
+		}
+	}
+	union := len(a) + len(b) - intersection
+	if union == 0 {
+		return 0
+	}
+	return float64(intersection) / float64(union)
+}
+
+// WriteRenameSuggestions prints the suggestions as patch rules that can be
+// pasted into a rule file.
+func WriteRenameSuggestions(w io.Writer, suggestions []RenameSuggestion) {
+	fmt.Fprintln(w, "patch_rules:")
+	for _, s := range suggestions {
+		fmt.Fprintf(w, "# confidence: %.2f\n", s.Confidence)
+		fmt.Fprintf(w, "- rename_object:\n")
+		fmt.Fprintf(w, "    from: %q\n", s.From.Name)
+		fmt.Fprintf(w, "    to: %q\n", s.To.Name)
+		fmt.Fprintf(w, "  /kind: [%q]\n", s.From.Kind)
+	}
+}
+
+func labelFeatures(doc interface{}) map[string]bool {
+	features := map[string]bool{}
+	for _, path := range []string{"/metadata/labels", "/spec/template/metadata/labels"} {
+		labels, _ := lookup(doc, path).(map[string]interface{})
+		for k, v := range labels {
+			features[k+"="+fmt.Sprint(v)] = true
+		}
+	}
+	return features
+}
+
+func imageFeatures(doc interface{}) map[string]bool {
+	features := map[string]bool{}
+	for _, container := range podContainers(doc) {
+		image, _ := container["image"].(string)
+		if image == "" {
+			continue
+		}
+		// Tags usually differ between both sides, only compare repositories.
+		if i := strings.LastIndexAny(image, ":@"); i > strings.LastIndex(image, "/") {
+			image = image[:i]
+		}
+		features[image] = true
+	}
+	return features
+}
+
+func argFeatures(doc interface{}) map[string]bool {
+	features := map[string]bool{}
+	for _, container := range podContainers(doc) {
+		for _, field := range []string{"command", "args"} {
+			args, _ := container[field].([]interface{})
+			for _, arg := range args {
+				features[fmt.Sprint(arg)] = true
+			}
+		}
+	}
+	return features
+}
+
+func specFeatures(doc interface{}) map[string]bool {
+	features := map[string]bool{}
+	collectLeafPaths(lookup(doc, "/spec"), "", features)
+	return features
+}
+
+func nameFeatures(doc interface{}) map[string]bool {
+	features := map[string]bool{}
+	name, _ := lookup(doc, "/metadata/name").(string)
+	for _, token := range strings.FieldsFunc(name, func(r rune) bool { return r == '-' || r == '.' }) {
+		features[token] = true
+	}
+	return features
+}
+
+// collectLeafPaths collects the paths of all leaves of a document. List
+// indices are replaced by "*" so that the structure is compared rather than
+// the exact position of every element.
+func collectLeafPaths(value interface{}, path string, into map[string]bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			collectLeafPaths(item, path+"/"+escapePointerToken(k), into)
+		}
+	case []interface{}:
+		for _, item := range v {
+			collectLeafPaths(item, path+"/*", into)
+		}
+	default:
+		if path != "" {
+			into[path] = true
+		}
+	}
+}
+
+func podContainers(doc interface{}) []map[string]interface{} {
+	var result []map[string]interface{}
+	for _, podSpec := range []string{"/spec/template/spec", "/spec/jobTemplate/spec/template/spec", "/spec"} {
+		for _, field := range []string{"initContainers", "containers"} {
+			containers, _ := lookup(doc, podSpec+"/"+field).([]interface{})
+			for _, c := range containers {
+				if container, ok := c.(map[string]interface{}); ok {
+					result = append(result, container)
+				}
+			}
+		}
+	}
+	return result
+}
+
+// lookup resolves a JSON pointer in a document, returning nil if it does not
+// exist.
+func lookup(doc interface{}, path string) interface{} {
+	value, _ := resolvePointer(doc, path)
+	return value
+}
@@ -0,0 +1,62 @@
+package differ
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectRenames(t *testing.T) {
+	t.Run("unpaired objects of the same kind are paired by similarity", func(t *testing.T) {
+		left := []*YamlObject{
+			newDeploymentWithLabels("mimir-querier", map[string]string{"app.kubernetes.io/component": "querier"}),
+			newDeploymentWithLabels("mimir-distributor", map[string]string{"app.kubernetes.io/component": "distributor"}),
+		}
+		right := []*YamlObject{
+			newDeploymentWithLabels("distributor", map[string]string{"app.kubernetes.io/component": "distributor"}),
+			newDeploymentWithLabels("querier", map[string]string{"app.kubernetes.io/component": "querier"}),
+		}
+
+		diffs, err := DiffObjectSets(left, right, DiffOptions{})
+		require.NoError(t, err)
+
+		suggestions, err := DetectRenames(diffs, 0.5)
+		require.NoError(t, err)
+		require.Len(t, suggestions, 2)
+		require.Equal(t, "mimir-distributor", suggestions[0].From.Name)
+		require.Equal(t, "distributor", suggestions[0].To.Name)
+		require.Equal(t, "mimir-querier", suggestions[1].From.Name)
+		require.Equal(t, "querier", suggestions[1].To.Name)
+		require.Greater(t, suggestions[0].Confidence, 0.5)
+	})
+
+	t.Run("objects of different kinds are never paired", func(t *testing.T) {
+		left := []*YamlObject{newDeploymentWithLabels("mimir-querier", map[string]string{"name": "querier"})}
+		right := []*YamlObject{newDeploymentWithLabels("querier", map[string]string{"name": "querier"})}
+		right[0].Object["kind"] = "StatefulSet"
+
+		diffs, err := DiffObjectSets(left, right, DiffOptions{})
+		require.NoError(t, err)
+
+		suggestions, err := DetectRenames(diffs, 0)
+		require.NoError(t, err)
+		require.Empty(t, suggestions)
+	})
+
+	t.Run("suggestions are printed as rename_object rules", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		WriteRenameSuggestions(buf, []RenameSuggestion{{
+			From:       ObjectIdentity{Kind: "StatefulSet", Name: "mimir-ingester"},
+			To:         ObjectIdentity{Kind: "StatefulSet", Name: "ingester"},
+			Confidence: 0.875,
+		}})
+		require.Equal(t, `patch_rules:
+# confidence: 0.88
+- rename_object:
+    from: "mimir-ingester"
+    to: "ingester"
+  /kind: ["StatefulSet"]
+`, buf.String())
+	})
+}
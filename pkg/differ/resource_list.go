@@ -0,0 +1,156 @@
+package differ
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// ResourceList is the input and output of KRM functions.
+type ResourceList struct {
+	APIVersion     string                   `yaml:"apiVersion"`
+	Kind           string                   `yaml:"kind"`
+	Items          []map[string]interface{} `yaml:"items"`
+	FunctionConfig map[string]interface{}   `yaml:"functionConfig,omitempty"`
+	Results        []ResourceListResult     `yaml:"results,omitempty"`
+}
+
+// ResourceListResult is a message of a KRM function about its run.
+type ResourceListResult struct {
+	Message  string `yaml:"message"`
+	Severity string `yaml:"severity,omitempty"`
+}
+
+// NewResourceList returns a ResourceList of the items.
+func NewResourceList(items []map[string]interface{}) *ResourceList {
+	return &ResourceList{
+		APIVersion: "config.kubernetes.io/v1",
+		Kind:       "ResourceList",
+		Items:      items,
+	}
+}
+
+// DecodeResourceList reads a ResourceList. Maps are decoded as
+// map[string]interface{}, like in YamlObject.Document.
+func DecodeResourceList(r io.Reader) (*ResourceList, error) {
+	var list ResourceList
+	if err := yamlv3.NewDecoder(r).Decode(&list); err != nil {
+		return nil, fmt.Errorf("invalid ResourceList: %v", err)
+	}
+	for i, item := range list.Items {
+		list.Items[i] = jsonCompatible(item).(map[string]interface{})
+	}
+	if list.FunctionConfig != nil {
+		list.FunctionConfig = jsonCompatible(list.FunctionConfig).(map[string]interface{})
+	}
+	return &list, nil
+}
+
+// EncodeResourceList writes a ResourceList.
+func EncodeResourceList(w io.Writer, list *ResourceList) error {
+	enc := yamlv3.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(list); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// RunKrmFunction runs yaml-patch as a KRM function: it reads a ResourceList,
+// applies the rules of the rule file in the spec of its functionConfig to the
+// items and writes the ResourceList back. Ineffective rules are reported as
+// results with severity warning. Invalid rules are reported as results with
+// severity error, and the function fails.
+func RunKrmFunction(r io.Reader, w io.Writer) error {
+	list, err := DecodeResourceList(r)
+	if err != nil {
+		return err
+	}
+
+	ruleSet, err := functionConfigRuleSet(list.FunctionConfig)
+	if err != nil {
+		list.Results = append(list.Results, errorResults(err, "error")...)
+		if err := EncodeResourceList(w, list); err != nil {
+			return err
+		}
+		return fmt.Errorf("invalid functionConfig")
+	}
+
+	objects := make([]*YamlObject, len(list.Items))
+	for i, item := range list.Items {
+		obj := &YamlObject{Object: item}
+		obj.ResourceKey.Source, _ = annotation(item, pathAnnotation)
+		if obj.ResourceKey.Source == "" {
+			obj.ResourceKey.Source = ObjectIdentityForObject(obj).String()
+		}
+		objects[i] = obj
+	}
+
+	debugInfo := NewDebugInfo(*ruleSet)
+	debugInfo.AddInitialObjects(objects)
+	results, err := ApplyRuleSet(objects, *ruleSet, debugInfo)
+	if err != nil {
+		list.Results = append(list.Results, errorResults(err, "error")...)
+		if err := EncodeResourceList(w, list); err != nil {
+			return err
+		}
+		return err
+	}
+
+	list.Items = make([]map[string]interface{}, len(results))
+	for i, obj := range results {
+		doc, err := obj.Document()
+		if err != nil {
+			return err
+		}
+		list.Items[i] = wholeNumbersToInts(doc).(map[string]interface{})
+	}
+	list.Results = append(list.Results, errorResults(debugInfo.ValidateAllRulesWereEffective(), "warning")...)
+	return EncodeResourceList(w, list)
+}
+
+// functionConfigRuleSet decodes the rule file in the spec of a functionConfig.
+func functionConfigRuleSet(functionConfig map[string]interface{}) (*RuleSet, error) {
+	spec, ok := functionConfig["spec"]
+	if !ok {
+		return nil, fmt.Errorf("functionConfig has no spec holding the rules")
+	}
+	buf, err := yamlv3.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	ruleSet, _, err := decodeRuleFile("functionConfig.spec", buf, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(ruleSet.Include) > 0 {
+		return nil, fmt.Errorf("include is not supported in functionConfig")
+	}
+	ruleSet.Desugar()
+	return ruleSet, nil
+}
+
+// errorResults converts an error into results, one per error of a MultiError.
+func errorResults(err error, severity string) []ResourceListResult {
+	if err == nil {
+		return nil
+	}
+	var multiError *MultiError
+	if !errors.As(err, &multiError) {
+		return []ResourceListResult{{Message: err.Error(), Severity: severity}}
+	}
+	var results []ResourceListResult
+	for _, err := range multiError.Errors {
+		results = append(results, errorResults(err, severity)...)
+	}
+	return results
+}
+
+func annotation(item map[string]interface{}, key string) (string, bool) {
+	metadata, _ := item["metadata"].(map[string]interface{})
+	annotations, _ := metadata["annotations"].(map[string]interface{})
+	value, ok := annotations[key].(string)
+	return value, ok
+}
@@ -0,0 +1,75 @@
+package differ
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunKrmFunction(t *testing.T) {
+	run := func(t *testing.T, input string) (*ResourceList, error) {
+		var out bytes.Buffer
+		err := RunKrmFunction(strings.NewReader(input), &out)
+		list, decodeErr := DecodeResourceList(&out)
+		require.NoError(t, decodeErr)
+		return list, err
+	}
+
+	t.Run("rules from the functionConfig are applied to the items", func(t *testing.T) {
+		list, err := run(t, `
+apiVersion: config.kubernetes.io/v1
+kind: ResourceList
+items:
+- apiVersion: apps/v1
+  kind: Deployment
+  metadata:
+    name: querier
+    annotations:
+      config.kubernetes.io/path: querier.yaml
+  spec:
+    replicas: 1000000
+- apiVersion: v1
+  kind: ConfigMap
+  metadata:
+    name: runtime
+functionConfig:
+  apiVersion: k8s-diff.grafana.com/v1alpha1
+  kind: YamlPatch
+  spec:
+    ignore_rules:
+    - name: ignore config maps
+      match:
+      - {op: test, path: /kind, value: ConfigMap}
+    patch_rules:
+    - remove_field: /metadata/labels
+`)
+		require.NoError(t, err)
+
+		require.Len(t, list.Items, 1)
+		require.Equal(t, "querier", list.Items[0]["metadata"].(map[string]interface{})["name"])
+		require.Equal(t, 1000000, list.Items[0]["spec"].(map[string]interface{})["replicas"], "numbers keep their form")
+
+		require.Len(t, list.Results, 1)
+		require.Equal(t, "warning", list.Results[0].Severity)
+		require.Contains(t, list.Results[0].Message, `rule "Remove /metadata/labels"`)
+		require.Contains(t, list.Results[0].Message, "querier.yaml")
+	})
+
+	t.Run("invalid rules are reported as errors", func(t *testing.T) {
+		list, err := run(t, `
+apiVersion: config.kubernetes.io/v1
+kind: ResourceList
+items: []
+functionConfig:
+  spec:
+    patch_rules:
+    - remove_feild: /metadata/labels
+`)
+		require.Error(t, err)
+		require.Len(t, list.Results, 1)
+		require.Equal(t, "error", list.Results[0].Severity)
+		require.Contains(t, list.Results[0].Message, `unknown field "remove_feild" in patch rule`)
+	})
+}
@@ -0,0 +1,303 @@
+package differ
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/google/go-jsonnet"
+	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// RuleLoader loads rule files and the files they include. Every file is
+// loaded at most once with the same vars, so that a file included by several
+// others doesn't run its rules twice, while a file included with different
+// vars, e.g. once per environment, runs once per set of vars.
+type RuleLoader struct {
+	// Vars override the vars declared in rule files, see ExpandVars.
+	Vars map[string]string
+	// JPath are the library search paths of Jsonnet rule files.
+	JPath []string
+	// ExtVars are the external variables of Jsonnet rule files.
+	ExtVars map[string]string
+
+	loaded map[string]bool
+	// stack are the files currently being loaded, used to detect include
+	// cycles.
+	stack []string
+}
+
+func NewRuleLoader() *RuleLoader {
+	return &RuleLoader{loaded: map[string]bool{}}
+}
+
+// LoadFile loads a rule file. The rules of included files come before the
+// rules of the including file. Vars are expanded in the whole file before it
+// is decoded. The vars of a file apply to the files it includes too, and
+// override their own vars.
+func (l *RuleLoader) LoadFile(path string) (*RuleSet, error) {
+	return l.loadFile(path, nil)
+}
+
+func (l *RuleLoader) loadFile(path string, inherited map[string]string) (*RuleSet, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	for i, loading := range l.stack {
+		if loading == abs {
+			return nil, fmt.Errorf("include cycle: %s", strings.Join(append(l.stack[i:], abs), " -> "))
+		}
+	}
+	ruleSet := &RuleSet{}
+
+	buf, err := l.readFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fileRules, vars, err := decodeRuleFile(path, buf, mergeVars(inherited, l.Vars))
+	var schemaErr SchemaError
+	var multiErr *MultiError
+	if errors.As(err, &schemaErr) || errors.As(err, &multiErr) {
+		// These already cite the file and position.
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode rule file %s: %v", path, err)
+	}
+	key := loadKey(abs, vars)
+	if l.loaded[key] {
+		return ruleSet, nil
+	}
+	l.loaded[key] = true
+	fileRules.SetSource(path)
+
+	l.stack = append(l.stack, abs)
+	defer func() { l.stack = l.stack[:len(l.stack)-1] }()
+
+	for _, include := range fileRules.Include {
+		paths, err := resolveInclude(filepath.Dir(path), include)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		for _, includePath := range paths {
+			included, err := l.loadFile(includePath, vars)
+			if err != nil {
+				return nil, err
+			}
+			ruleSet.Merge(included)
+		}
+	}
+
+	ruleSet.Merge(fileRules)
+	return ruleSet, nil
+}
+
+// loadKey identifies a file loaded with a set of vars.
+func loadKey(abs string, vars map[string]string) string {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	sb.WriteString(abs)
+	for _, name := range names {
+		fmt.Fprintf(&sb, "\x00%s=%s", name, vars[name])
+	}
+	return sb.String()
+}
+
+// readFile reads a rule file. Jsonnet files are evaluated, their JSON output
+// is read like a YAML rule file.
+func (l *RuleLoader) readFile(path string) ([]byte, error) {
+	if !isJsonnetFile(path) {
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open rule file: %v", err)
+		}
+		return buf, nil
+	}
+
+	vm := jsonnet.MakeVM()
+	vm.Importer(&jsonnet.FileImporter{JPaths: l.JPath})
+	for name, value := range l.ExtVars {
+		vm.ExtVar(name, value)
+	}
+	out, err := vm.EvaluateFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate rule file %s: %v", path, err)
+	}
+	return []byte(out), nil
+}
+
+func isJsonnetFile(path string) bool {
+	ext := filepath.Ext(path)
+	return ext == ".jsonnet" || ext == ".libsonnet"
+}
+
+// LoadDir loads every .yaml, .yml and .jsonnet file of a directory, sorted by
+// name. Subdirectories and .libsonnet libraries are not loaded.
+func (l *RuleLoader) LoadDir(dir string) (*RuleSet, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rule directory: %v", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if ext := filepath.Ext(entry.Name()); !entry.IsDir() && (ext == ".yaml" || ext == ".yml" || ext == ".jsonnet") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	ruleSet := &RuleSet{}
+	for _, name := range names {
+		fileRules, err := l.loadFile(filepath.Join(dir, name), nil)
+		if err != nil {
+			return nil, err
+		}
+		ruleSet.Merge(fileRules)
+	}
+	return ruleSet, nil
+}
+
+// decodeRuleFile decodes a rule file after expanding its vars, which are
+// the vars it declares overridden by the given ones. The expanded file is
+// validated strictly before decoding. It returns the rules and the vars used.
+func decodeRuleFile(source string, buf []byte, overrides map[string]string) (*RuleSet, map[string]string, error) {
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(buf, &doc); err != nil {
+		return nil, nil, err
+	}
+	if doc.Kind == 0 {
+		return &RuleSet{}, overrides, nil
+	}
+
+	var declared struct {
+		Vars map[string]string `yaml:"vars"`
+	}
+	if err := doc.Decode(&declared); err != nil {
+		return nil, nil, err
+	}
+	vars := mergeVars(declared.Vars, overrides)
+
+	removeKey(doc.Content[0], "vars")
+	if err := expandVarsInNode(source, &doc, vars); err != nil {
+		return nil, nil, err
+	}
+	if err := validateRuleFile(source, &doc); err != nil {
+		return nil, nil, err
+	}
+
+	expanded, err := yamlv3.Marshal(&doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	ruleSet := &RuleSet{}
+	if err := yaml.Unmarshal(expanded, ruleSet); err != nil {
+		return nil, nil, err
+	}
+	setRuleLines(ruleSet, doc.Content[0])
+	return ruleSet, vars, nil
+}
+
+// setRuleLines records the line of every rule of a decoded rule file.
+func setRuleLines(ruleSet *RuleSet, root *yamlv3.Node) {
+	var items = func(node *yamlv3.Node, key string) []*yamlv3.Node {
+		node = resolveAlias(node)
+		for i := 0; node.Kind == yamlv3.MappingNode && i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				return resolveAlias(node.Content[i+1]).Content
+			}
+		}
+		return nil
+	}
+	var setRules = func(rules []Rule, nodes []*yamlv3.Node) {
+		for i := 0; i < len(rules) && i < len(nodes); i++ {
+			_, line := rules[i].location()
+			*line = nodes[i].Line
+		}
+	}
+
+	for i, node := range items(root, "ignore_rules") {
+		if i < len(ruleSet.IgnoreRules) {
+			ruleSet.IgnoreRules[i].Line = node.Line
+		}
+	}
+	for i, node := range items(root, "patch_rules") {
+		if i < len(ruleSet.PatchRules) {
+			ruleSet.PatchRules[i].Line = node.Line
+		}
+	}
+	setRules(ruleSet.Rules, items(root, "rules"))
+	for i, node := range items(root, "phases") {
+		if i < len(ruleSet.NamedPhases) {
+			setRules(ruleSet.NamedPhases[i].Rules, items(node, "rules"))
+		}
+	}
+}
+
+// resolveInclude returns the files an include refers to, relative to the
+// directory of the including file. Globs may match no file, other includes
+// must exist.
+func resolveInclude(dir, include string) ([]string, error) {
+	if !filepath.IsAbs(include) {
+		include = filepath.Join(dir, include)
+	}
+	paths, err := filepath.Glob(include)
+	if err != nil {
+		return nil, fmt.Errorf("invalid include %q: %v", include, err)
+	}
+	if len(paths) == 0 && !strings.ContainsAny(include, "*?[") {
+		return nil, fmt.Errorf("included file %s does not exist", include)
+	}
+	return paths, nil
+}
+
+// SetSource records the file the rules were loaded from.
+func (r *RuleSet) SetSource(source string) {
+	for i := range r.IgnoreRules {
+		r.IgnoreRules[i].Source = source
+	}
+	for i := range r.PatchRules {
+		r.PatchRules[i].Source = source
+	}
+	setRuleSources(r.Rules, source)
+	for _, phase := range r.NamedPhases {
+		setRuleSources(phase.Rules, source)
+	}
+}
+
+func setRuleSources(rules []Rule, source string) {
+	for _, rule := range rules {
+		ruleSource, _ := rule.location()
+		*ruleSource = source
+	}
+}
+
+// WriteRuleList prints every rule in the order it runs, with its phase and
+// the file it was loaded from.
+func WriteRuleList(w io.Writer, ruleSet *RuleSet) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PHASE\tSOURCE\tRULE")
+	for _, phase := range ruleSet.Phases() {
+		for _, rule := range phase.Rules {
+			description := rule.ObjectRule().Describe()
+			source := description.Source
+			if source == "" {
+				source = "-"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", phase.Name, source, description.Name)
+		}
+	}
+	return tw.Flush()
+}
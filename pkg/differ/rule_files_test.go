@@ -0,0 +1,168 @@
+package differ
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuleLoader(t *testing.T) {
+	writeFiles := func(t *testing.T, files map[string]string) string {
+		dir := t.TempDir()
+		for name, content := range files {
+			path := filepath.Join(dir, name)
+			require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+			require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		}
+		return dir
+	}
+
+	ruleNames := func(ruleSet *RuleSet) []string {
+		var names []string
+		for _, rule := range ruleSet.ObjectRules() {
+			names = append(names, rule.Describe().Name)
+		}
+		return names
+	}
+
+	t.Run("included files are loaded before the including file", func(t *testing.T) {
+		dir := writeFiles(t, map[string]string{
+			"main.yaml": `
+include: [renames/*.yaml, common.yaml]
+patch_rules:
+- name: main
+`,
+			"renames/b.yaml": "patch_rules: [{name: b}]",
+			"renames/a.yaml": "patch_rules: [{name: a}]",
+			"common.yaml": `
+include: [renames/a.yaml]
+patch_rules: [{name: common}]
+`,
+		})
+
+		ruleSet, err := NewRuleLoader().LoadFile(filepath.Join(dir, "main.yaml"))
+		require.NoError(t, err)
+		require.Equal(t, []string{"a", "b", "common", "main"}, ruleNames(ruleSet), "every file is loaded once")
+		require.Equal(t, filepath.Join(dir, "renames/a.yaml"), ruleSet.PatchRules[0].Source)
+	})
+
+	t.Run("files included with different vars are loaded once per vars", func(t *testing.T) {
+		dir := writeFiles(t, map[string]string{
+			"main.yaml": "include: [dev.yaml, prod.yaml, other-dev.yaml]",
+			"dev.yaml": `
+vars: {ns: dev}
+include: [common.yaml]
+`,
+			"prod.yaml": `
+vars: {ns: prod}
+include: [common.yaml]
+`,
+			"other-dev.yaml": `
+vars: {ns: dev}
+include: [common.yaml]
+`,
+			"common.yaml": `patch_rules: [{name: "common-${ns}"}]`,
+		})
+
+		ruleSet, err := NewRuleLoader().LoadFile(filepath.Join(dir, "main.yaml"))
+		require.NoError(t, err)
+		require.Equal(t, []string{"common-dev", "common-prod"}, ruleNames(ruleSet))
+	})
+
+	t.Run("include cycles are detected", func(t *testing.T) {
+		dir := writeFiles(t, map[string]string{
+			"a.yaml": "include: [b.yaml]",
+			"b.yaml": "include: [a.yaml]",
+		})
+
+		_, err := NewRuleLoader().LoadFile(filepath.Join(dir, "a.yaml"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "include cycle")
+	})
+
+	t.Run("missing includes are reported", func(t *testing.T) {
+		dir := writeFiles(t, map[string]string{
+			"a.yaml": "include: [missing.yaml, none/*.yaml]",
+		})
+
+		_, err := NewRuleLoader().LoadFile(filepath.Join(dir, "a.yaml"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "missing.yaml does not exist")
+	})
+
+	t.Run("directories are loaded in sorted order", func(t *testing.T) {
+		dir := writeFiles(t, map[string]string{
+			"20-patch.yml":   "patch_rules: [{name: second}]",
+			"10-patch.yaml":  "patch_rules: [{name: first}]",
+			"README.md":      "not a rule file",
+			"sub/skip.yaml":  "patch_rules: [{name: skipped}]",
+			"30-ignore.yaml": "ignore_rules: [{name: ignore}]",
+		})
+
+		ruleSet, err := NewRuleLoader().LoadDir(dir)
+		require.NoError(t, err)
+		require.Equal(t, []string{"ignore", "first", "second"}, ruleNames(ruleSet))
+
+		var buf bytes.Buffer
+		require.NoError(t, WriteRuleList(&buf, ruleSet))
+		require.Contains(t, buf.String(), filepath.Join(dir, "30-ignore.yaml"))
+	})
+
+	t.Run("validation errors cite the rule file", func(t *testing.T) {
+		dir := writeFiles(t, map[string]string{
+			"rules.yaml": "patch_rules: [{remove_field: /spec/missing}]",
+		})
+
+		ruleSet, err := NewRuleLoader().LoadFile(filepath.Join(dir, "rules.yaml"))
+		require.NoError(t, err)
+		ruleSet.Desugar()
+
+		objects := []*YamlObject{newDeploymentWithLabels("querier", nil)}
+		debugInfo := NewDebugInfo(*ruleSet)
+		debugInfo.AddInitialObjects(objects)
+		_, err = ApplyRuleSet(objects, *ruleSet, debugInfo)
+		require.NoError(t, err)
+
+		err = debugInfo.ValidateAllRulesWereEffective()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), filepath.Join(dir, "rules.yaml")+":1: rule")
+	})
+}
+
+func TestJsonnetRuleFiles(t *testing.T) {
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "lib")
+	require.NoError(t, os.MkdirAll(lib, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(lib, "components.libsonnet"), []byte(`
+["querier", "ingester"]
+`), 0644))
+	path := filepath.Join(dir, "renames.jsonnet")
+	require.NoError(t, os.WriteFile(path, []byte(`
+local components = import "components.libsonnet";
+{
+  patch_rules: [
+    {
+      rename_object: { from: std.extVar("prefix") + "-" + c, to: c },
+    }
+    for c in components
+  ],
+}
+`), 0644))
+
+	loader := NewRuleLoader()
+	loader.JPath = []string{lib}
+	loader.ExtVars = map[string]string{"prefix": "mimir"}
+	ruleSet, err := loader.LoadFile(path)
+	require.NoError(t, err)
+	ruleSet.Desugar()
+
+	var names []string
+	for _, rule := range ruleSet.ObjectRules() {
+		names = append(names, rule.Describe().Name)
+	}
+	require.Equal(t, []string{"Rename mimir-querier to querier", "Rename mimir-ingester to ingester"}, names)
+	require.Equal(t, path, ruleSet.PatchRules[0].Source)
+}
@@ -0,0 +1,446 @@
+package differ
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SchemaError is a problem in a rule file, at the position of the offending
+// node.
+type SchemaError struct {
+	Source string
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e SchemaError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.Source, e.Line, e.Column, e.Msg)
+}
+
+// patchOps are the RFC 6902 operations, which are applied by json-patch.
+var patchOps = map[string]bool{
+	"add":     true,
+	"remove":  true,
+	"replace": true,
+	"move":    true,
+	"copy":    true,
+	"test":    true,
+}
+
+// IsKnownOp returns true if op can be used in match or steps.
+func IsKnownOp(op string) bool {
+	return patchOps[op] || op == "substitute" || IsMatchOp(op)
+}
+
+// opRequires returns whether an operation requires from and value.
+func opRequires(op string) (from, value bool) {
+	switch op {
+	case "move", "copy":
+		return true, false
+	case "remove", "test_exists", "test_absent":
+		return false, false
+	}
+	return false, true
+}
+
+// ValidatePointer checks that a path is a well-formed JSON pointer, which may
+// use the extended path syntax.
+func ValidatePointer(path string) error {
+	if _, err := parsePath(path); err != nil {
+		return err
+	}
+	for i := 0; i < len(path); i++ {
+		if path[i] == '~' && (i+1 == len(path) || (path[i+1] != '0' && path[i+1] != '1')) {
+			return fmt.Errorf("path %q has an invalid escape, ~ must be followed by 0 or 1", path)
+		}
+	}
+	return nil
+}
+
+// ruleValidator collects the schema errors of a rule file.
+type ruleValidator struct {
+	source string
+	errors []error
+}
+
+// validateRuleFile validates the document of a rule file against the fields of
+// RuleSet. Unknown keys are rejected, since the inline matchers of patch rules
+// would otherwise turn a typo into a matcher on a bogus path.
+func validateRuleFile(source string, doc *yaml.Node) error {
+	v := &ruleValidator{source: source}
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
+		v.validateRuleSet(doc.Content[0])
+	}
+	if len(v.errors) > 0 {
+		return &MultiError{Errors: v.errors}
+	}
+	return nil
+}
+
+func (v *ruleValidator) errorf(node *yaml.Node, format string, args ...interface{}) {
+	v.errors = append(v.errors, SchemaError{
+		Source: v.source,
+		Line:   node.Line,
+		Column: node.Column,
+		Msg:    fmt.Sprintf(format, args...),
+	})
+}
+
+// fields checks that node is a mapping with only known keys, returning the
+// values by key. Keys accepted by extra are also allowed.
+func (v *ruleValidator) fields(node *yaml.Node, what string, known map[string]bool, extra func(key, value *yaml.Node) bool) map[string]*yaml.Node {
+	node = resolveAlias(node)
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return nil
+	}
+	if node.Kind != yaml.MappingNode {
+		v.errorf(node, "%s must be a map", what)
+		return nil
+	}
+	result := map[string]*yaml.Node{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], resolveAlias(node.Content[i+1])
+		switch {
+		case known[key.Value]:
+			result[key.Value] = value
+		case extra != nil && extra(key, value):
+		default:
+			v.errorf(key, "unknown field %q in %s", key.Value, what)
+		}
+	}
+	return result
+}
+
+func (v *ruleValidator) sequence(node *yaml.Node, what string, item func(*yaml.Node)) {
+	if node == nil || (node.Kind == yaml.ScalarNode && node.Tag == "!!null") {
+		return
+	}
+	node = resolveAlias(node)
+	if node.Kind != yaml.SequenceNode {
+		v.errorf(node, "%s must be a list", what)
+		return
+	}
+	for _, n := range node.Content {
+		item(n)
+	}
+}
+
+func (v *ruleValidator) pointer(node *yaml.Node) {
+	if node == nil {
+		return
+	}
+	if node.Kind != yaml.ScalarNode {
+		v.errorf(node, "path must be a string")
+		return
+	}
+	if err := ValidatePointer(node.Value); err != nil {
+		v.errorf(node, "%v", err)
+	}
+}
+
+func (v *ruleValidator) celExpression(node *yaml.Node) {
+	if node == nil {
+		return
+	}
+	if node.Kind != yaml.ScalarNode {
+		v.errorf(node, "when must be a string")
+		return
+	}
+	if _, err := CompileCEL(node.Value); err != nil {
+		v.errorf(node, "%v", err)
+	}
+}
+
+// regexp reports a regular expression of a test_regex or substitute
+// operation which doesn't compile.
+func (v *ruleValidator) regexp(node *yaml.Node) {
+	if node == nil || node.Kind != yaml.ScalarNode {
+		return
+	}
+	if _, err := compileRegexp(node.Value); err != nil {
+		v.errorf(node, "%v", err)
+	}
+}
+
+// opValue validates the value of an operation which must be known when the
+// rule file is loaded.
+func (v *ruleValidator) opValue(op string, value *yaml.Node) {
+	if value == nil {
+		return
+	}
+	value = resolveAlias(value)
+	switch op {
+	case "test_regex":
+		v.regexp(value)
+	case "substitute":
+		params := v.fields(value, "substitute value", map[string]bool{"pattern": true, "replacement": true}, nil)
+		if value.Kind == yaml.MappingNode && params["pattern"] == nil {
+			v.errorf(value, "substitute value has no pattern")
+		}
+		v.regexp(params["pattern"])
+		v.template(params["replacement"])
+	}
+}
+
+// ambiguousGroupReference matches $$, which is a literal $, and references to
+// numbered capture groups directly followed by a letter or underscore.
+var ambiguousGroupReference = regexp.MustCompile(`\$\$|\$([0-9]+)([A-Za-z_][A-Za-z0-9_]*)`)
+
+// template reports references in a replacement template which Go reads as a
+// named group, e.g. $1_old as the group "1_old" rather than $1 followed by
+// "_old". Named groups can't start with a digit, so they expand to nothing.
+func (v *ruleValidator) template(node *yaml.Node) {
+	if node == nil || node.Kind != yaml.ScalarNode {
+		return
+	}
+	for _, match := range ambiguousGroupReference.FindAllStringSubmatch(node.Value, -1) {
+		if match[1] == "" {
+			continue
+		}
+		v.errorf(node, "%s in template %q refers to a group named %q, write ${%s}%s", match[0], node.Value, match[1]+match[2], match[1], match[2])
+	}
+}
+
+func (v *ruleValidator) target(node *yaml.Node) {
+	if node == nil {
+		return
+	}
+	fields := v.fields(node, "target", yamlFields(reflect.TypeOf(Target{})), nil)
+	for key, value := range fields {
+		if value.Kind != yaml.ScalarNode {
+			v.errorf(value, "target %s must be a string", key)
+			return
+		}
+	}
+	var target Target
+	if err := node.Decode(&target); err != nil {
+		return
+	}
+	if err := target.Validate(); err != nil {
+		v.errorf(node, "%v", err)
+	}
+}
+
+func (v *ruleValidator) validateRuleSet(node *yaml.Node) {
+	fields := v.fields(node, "rule file", yamlFields(reflect.TypeOf(RuleSet{}), "vars"), nil)
+	v.sequence(fields["ignore_rules"], "ignore_rules", v.validateIgnoreRule)
+	v.sequence(fields["patch_rules"], "patch_rules", v.validatePatchRule)
+	v.sequence(fields["rules"], "rules", v.validateRule)
+	v.sequence(fields["phases"], "phases", func(node *yaml.Node) {
+		phase := v.fields(node, "phase", yamlFields(reflect.TypeOf(Phase{})), nil)
+		v.sequence(phase["rules"], "rules", v.validateRule)
+	})
+	v.sequence(fields["reference_paths"], "reference_paths", func(node *yaml.Node) {
+		ref := v.fields(node, "reference path", yamlFields(reflect.TypeOf(ReferencePath{})), nil)
+		v.pointer(ref["path"])
+	})
+}
+
+func (v *ruleValidator) validateRule(node *yaml.Node) {
+	node = resolveAlias(node)
+	rule := v.fields(node, "rule", yamlFields(reflect.TypeOf(Rule{})), nil)
+	if node.Kind == yaml.MappingNode && len(rule) != 1 {
+		v.errorf(node, "a rule must have exactly one of %s", ruleKinds)
+	}
+	if rule["ignore"] != nil {
+		v.validateIgnoreRule(rule["ignore"])
+	}
+	if rule["patch"] != nil {
+		v.validatePatchRule(rule["patch"])
+	}
+	if rule["script"] != nil {
+		v.validateScriptRule(rule["script"])
+	}
+	if rule["krm_function"] != nil {
+		fields := v.fields(rule["krm_function"], "krm_function rule", yamlFields(reflect.TypeOf(KrmFunctionRule{})), nil)
+		if fields != nil && fields["exec"] == nil {
+			v.errorf(rule["krm_function"], "krm_function rule has no exec")
+		}
+		if timeout := fields["timeout"]; timeout != nil {
+			if _, err := (KrmFunctionRule{Timeout: timeout.Value}).timeout(); err != nil {
+				v.errorf(timeout, "%v", err)
+			}
+		}
+	}
+}
+
+func (v *ruleValidator) validateScriptRule(node *yaml.Node) {
+	rule := v.fields(node, "script rule", yamlFields(reflect.TypeOf(ScriptRule{})), nil)
+	v.validatePatch(rule["match"], "match")
+	v.celExpression(rule["when"])
+	v.target(rule["target"])
+	program := rule["program"]
+	if program == nil {
+		if node.Kind == yaml.MappingNode {
+			v.errorf(node, "script rule has no program")
+		}
+		return
+	}
+	if program.Kind != yaml.ScalarNode {
+		v.errorf(program, "program must be a string")
+		return
+	}
+	if _, err := CompileScript(program.Value); err != nil {
+		v.errorf(program, "%v", err)
+	}
+}
+
+func (v *ruleValidator) validateIgnoreRule(node *yaml.Node) {
+	rule := v.fields(node, "ignore rule", yamlFields(reflect.TypeOf(IgnoreRule{})), nil)
+	v.validatePatch(rule["match"], "match")
+	v.celExpression(rule["when"])
+	v.target(rule["target"])
+}
+
+func (v *ruleValidator) validatePatchRule(node *yaml.Node) {
+	rule := v.fields(node, "patch rule", yamlFields(reflect.TypeOf(Json6902PatchRule{})), func(key, value *yaml.Node) bool {
+		if !strings.HasPrefix(key.Value, "/") {
+			return false
+		}
+		// Matchers are keyed by the path they match on.
+		v.pointer(key)
+		if value.Kind != yaml.SequenceNode {
+			v.errorf(value, "matcher %s must be a list of values", key.Value)
+			return true
+		}
+		// Values can be a map with a match operation as only key.
+		for _, item := range value.Content {
+			item = resolveAlias(item)
+			if item.Kind == yaml.MappingNode && len(item.Content) == 2 {
+				v.opValue(item.Content[0].Value, item.Content[1])
+			}
+		}
+		return true
+	})
+	v.validatePatch(rule["match"], "match")
+	v.validatePatch(rule["steps"], "steps")
+	v.celExpression(rule["when"])
+	v.target(rule["target"])
+	v.pointer(rule["remove_field"])
+	if rename := rule["rename_field"]; rename != nil {
+		fields := v.fields(rename, "rename_field", yamlFields(reflect.TypeOf(RenameRule{})), nil)
+		v.pointer(fields["from"])
+		v.pointer(fields["to"])
+	}
+	if rename := rule["rename_object"]; rename != nil {
+		fields := v.fields(rename, "rename_object", yamlFields(reflect.TypeOf(RenameRule{})), nil)
+		if regex := fields["regex"]; regex != nil && regex.Value == "true" {
+			v.regexp(fields["from"])
+			v.template(fields["to"])
+		}
+	}
+	if copyField := rule["copy_field"]; copyField != nil {
+		fields := v.fields(copyField, "copy_field", yamlFields(reflect.TypeOf(FieldCopy{})), nil)
+		for _, field := range []string{"from", "to"} {
+			if copyField.Kind == yaml.MappingNode && fields[field] == nil {
+				v.errorf(copyField, "copy_field has no %s", field)
+			}
+		}
+		v.pointer(fields["from"])
+		v.pointer(fields["to"])
+	}
+	v.sequence(rule["remove_fields"], "remove_fields", v.pointer)
+	for _, key := range []string{"set_field", "add_if_missing", "remove_if_equals"} {
+		if node := rule[key]; node != nil {
+			fields := v.fields(node, key, yamlFields(reflect.TypeOf(FieldValue{})), nil)
+			if node.Kind == yaml.MappingNode && fields["path"] == nil {
+				v.errorf(node, "%s has no path", key)
+			}
+			if node.Kind == yaml.MappingNode && fields["value"] == nil {
+				v.errorf(node, "%s has no value", key)
+			}
+			v.pointer(fields["path"])
+		}
+	}
+}
+
+func (v *ruleValidator) validatePatch(node *yaml.Node, what string) {
+	v.sequence(node, what, func(node *yaml.Node) {
+		node = resolveAlias(node)
+		op := v.fields(node, "operation", yamlFields(reflect.TypeOf(Json6902Operation{})), nil)
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		if op["op"] == nil {
+			v.errorf(node, "operation has no op")
+			return
+		}
+		name := op["op"].Value
+		if !IsKnownOp(name) {
+			v.errorf(op["op"], "unknown op %q", name)
+			return
+		}
+		if op["path"] == nil {
+			v.errorf(node, "%s operation has no path", name)
+		}
+		v.pointer(op["path"])
+		needsFrom, needsValue := opRequires(name)
+		if needsFrom && op["from"] == nil {
+			v.errorf(node, "%s operation has no from", name)
+		}
+		if needsValue && op["value"] == nil {
+			v.errorf(node, "%s operation has no value", name)
+		}
+		v.pointer(op["from"])
+		v.opValue(name, op["value"])
+	})
+}
+
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	return node
+}
+
+// yamlFields returns the keys of the fields of a struct, including the fields
+// of inline structs, as well as the given extra keys.
+func yamlFields(t reflect.Type, extra ...string) map[string]bool {
+	result := map[string]bool{}
+	for _, key := range extra {
+		result[key] = true
+	}
+	for _, field := range yamlStructFields(t) {
+		result[field.name] = true
+	}
+	return result
+}
+
+type yamlStructField struct {
+	name  string
+	field reflect.StructField
+}
+
+// yamlStructFields returns the fields of a struct as they appear in YAML, in
+// declaration order. Fields of inline structs are included, inline maps are
+// not.
+func yamlStructFields(t reflect.Type) []yamlStructField {
+	var result []yamlStructField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		tag := field.Tag.Get("yaml")
+		name := strings.Split(tag, ",")[0]
+		if name == "-" {
+			continue
+		}
+		if strings.Contains(tag, ",inline") {
+			if field.Type.Kind() == reflect.Struct {
+				result = append(result, yamlStructFields(field.Type)...)
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		result = append(result, yamlStructField{name: name, field: field})
+	}
+	return result
+}
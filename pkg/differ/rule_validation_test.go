@@ -0,0 +1,176 @@
+package differ
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateRuleFile(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		rules    string
+		expected []string
+	}{
+		{
+			name: "valid rules",
+			rules: `
+ignore_rules:
+- name: ignore secrets
+  match: [{op: test, path: /kind, value: Secret}]
+  other_side: absent
+patch_rules:
+- remove_field: /metadata/annotations
+  /kind: [Deployment]
+- rename_object: {from: a, to: b, regex: true}
+  inputs: [helm]
+- steps:
+  - {op: move, from: /a, path: /b}
+  - {op: test_exists, path: "/spec/containers[name=querier]/image"}
+rules:
+- ignore: {name: ignore}
+phases:
+- name: cleanup
+  rules:
+  - patch: {remove_field: /spec/replicas}
+`,
+		},
+		{
+			name: "typos in rule fields",
+			rules: `
+patch_rules:
+- remove_feild: /metadata/annotations
+- stpes: []
+`,
+			expected: []string{
+				`rules.yaml:3:3: unknown field "remove_feild" in patch rule`,
+				`rules.yaml:4:3: unknown field "stpes" in patch rule`,
+			},
+		},
+		{
+			name: "unknown top level fields",
+			rules: `
+patch_rule: []
+`,
+			expected: []string{`rules.yaml:2:1: unknown field "patch_rule" in rule file`},
+		},
+		{
+			name: "unknown ops and missing fields",
+			rules: `
+patch_rules:
+- match:
+  - {op: tset, path: /kind, value: Secret}
+  - {op: test, path: /kind}
+  steps:
+  - {op: move, path: /b}
+  - {path: /b}
+  - {op: remove, path: metadata}
+  - {op: remove, path: /a~2b}
+`,
+			expected: []string{
+				`rules.yaml:4:10: unknown op "tset"`,
+				`rules.yaml:5:5: test operation has no value`,
+				`rules.yaml:7:5: move operation has no from`,
+				`rules.yaml:8:5: operation has no op`,
+				`rules.yaml:9:24: path "metadata" must start with /`,
+				`rules.yaml:10:24: path "/a~2b" has an invalid escape, ~ must be followed by 0 or 1`,
+			},
+		},
+		{
+			name: "field shorthands",
+			rules: `
+patch_rules:
+- set_field: {path: /spec/replicas, value: 1}
+- copy_field: {from: /a, to: b}
+- remove_fields: [/a, b]
+- add_if_missing: {value: 1}
+- remove_if_equals: {path: /a, vaule: 1}
+- copy_field: {from: /a, to: /b, regex: true}
+- copy_field: {from: /a}
+- set_field: {path: /spec/replicas}
+`,
+			expected: []string{
+				`rules.yaml:4:30: path "b" must start with /`,
+				`rules.yaml:5:23: path "b" must start with /`,
+				`rules.yaml:6:19: add_if_missing has no path`,
+				`rules.yaml:7:32: unknown field "vaule" in remove_if_equals`,
+				`rules.yaml:7:21: remove_if_equals has no value`,
+				`rules.yaml:8:34: unknown field "regex" in copy_field`,
+				`rules.yaml:9:15: copy_field has no to`,
+				`rules.yaml:10:14: set_field has no value`,
+			},
+		},
+		{
+			name: "matchers",
+			rules: `
+patch_rules:
+- /kind: Deployment
+  /metadata/labels/a~b: [a]
+`,
+			expected: []string{
+				`rules.yaml:3:10: matcher /kind must be a list of values`,
+				`rules.yaml:4:3: path "/metadata/labels/a~b" has an invalid escape`,
+			},
+		},
+		{
+			name: "regular expressions",
+			rules: `
+patch_rules:
+- match: [{op: test_regex, path: /metadata/name, value: "querier("}]
+  steps: [{op: substitute, path: /metadata/name, value: {pattern: "(", replacement: a}}]
+- /metadata/name: [{test_regex: "[a-"}, querier(]
+- rename_object: {from: "mimir-(", to: "$1", regex: true}
+- rename_object: {from: "mimir-(", to: "querier"}
+- rename_object: {from: "mimir-(.*)", to: "$1_old", regex: true}
+- rename_object: {from: "mimir-(.*)", to: "${1}_old-$1-$$1x", regex: true}
+- steps: [{op: substitute, path: /metadata/name, value: {pattern: "(.*)", replacement: "$1x"}}]
+`,
+			expected: []string{
+				`rules.yaml:3:57: invalid regular expression "querier("`,
+				`rules.yaml:4:67: invalid regular expression "("`,
+				`rules.yaml:5:33: invalid regular expression "[a-"`,
+				`rules.yaml:6:25: invalid regular expression "mimir-("`,
+				`rules.yaml:8:43: $1_old in template "$1_old" refers to a group named "1_old", write ${1}_old`,
+				`rules.yaml:10:88: $1x in template "$1x" refers to a group named "1x", write ${1}x`,
+			},
+		},
+		{
+			name: "krm functions",
+			rules: `
+rules:
+- krm_function: {exec: set-namespace, timeout: 30s}
+- krm_function: {exec: set-namespace, timeout: 30}
+- krm_function: {exec: set-namespace, timeout: -1s}
+`,
+			expected: []string{
+				`rules.yaml:4:48: invalid timeout "30"`,
+				`rules.yaml:5:48: invalid timeout "-1s": must be positive`,
+			},
+		},
+		{
+			name: "rules entries",
+			rules: `
+rules:
+- name: neither
+`,
+			expected: []string{
+				`rules.yaml:3:3: unknown field "name" in rule`,
+				`rules.yaml:3:3: a rule must have exactly one of ignore, patch, script or krm_function`,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := decodeRuleFile("rules.yaml", []byte(tc.rules), nil)
+			if len(tc.expected) == 0 {
+				require.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			errs := err.(*MultiError).Errors
+			require.Len(t, errs, len(tc.expected), err.Error())
+			for i, expected := range tc.expected {
+				require.Contains(t, errs[i].Error(), expected)
+			}
+		})
+	}
+}
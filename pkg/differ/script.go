@@ -0,0 +1,251 @@
+package differ
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"go.starlark.net/starlark"
+)
+
+// maxScriptSteps bounds the computation of a script on one object, so that a
+// script looping forever fails instead of hanging yaml-patch.
+const maxScriptSteps = 10000000
+
+// ScriptRule transforms the objects it matches with a Starlark program. The
+// program must define a function transform(object), which gets the object as
+// a dict and returns the modified object, or None to remove it from the
+// output. Scripts have no access to the filesystem or the network, and can't
+// load other files.
+type ScriptRule struct {
+	Name  string        `yaml:"name,omitempty"`
+	Match Json6902Patch `yaml:"match,omitempty"`
+
+	// When is a CEL expression which must hold for the object, in addition
+	// to Match.
+	When string `yaml:"when,omitempty"`
+
+	// Target selects the objects of the rule like the target of kustomize
+	// patches, in addition to Match.
+	Target *Target `yaml:"target,omitempty"`
+
+	// Program is the Starlark source of the script.
+	Program string `yaml:"program"`
+
+	Todo bool `yaml:"todo,omitempty"`
+
+	// Inputs restricts the rule to the named inputs.
+	Inputs []string `yaml:"inputs,omitempty"`
+
+	// Source is the rule file the rule was loaded from, and Line the line of
+	// the rule in it.
+	Source string `yaml:"-"`
+	Line   int    `yaml:"-"`
+}
+
+func (s ScriptRule) Describe() ObjectRuleDescription {
+	name := s.Name
+	if name == "" {
+		name = "script"
+	}
+	return ObjectRuleDescription{
+		Name:       name,
+		Todo:       s.Todo,
+		MatchRules: s.Match,
+		Conditions: append(whenDescription(s.When), targetDescription(s.Target)...),
+		// The script is a single step, which must change or remove at least
+		// one object.
+		PatchRules: Json6902Patch{{Op: "script"}},
+		Inputs:     s.Inputs,
+		Source:     s.Source,
+		Line:       s.Line,
+	}
+}
+
+func (s ScriptRule) MapObject(obj *YamlObject, debug *RuleDebugInfo) (*YamlObject, error) {
+	ok, err := s.Match.Matches(obj, debug)
+	if err != nil || !ok {
+		// Errors are ignored like in the match of patch rules.
+		return obj, nil
+	}
+
+	ok, err = matchesWhen(s.When, obj, 0, debug)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return obj, nil
+	}
+
+	ok, err = matchesTarget(s.Target, obj, len(whenDescription(s.When)), debug)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return obj, nil
+	}
+
+	result, err := s.run(obj)
+	if err != nil {
+		return nil, fmt.Errorf("script %q on %s: %v", s.Describe().Name, ResourceKeyForObject(obj), err)
+	}
+	if result == nil {
+		debug.RecordIgnore(obj)
+		return nil, nil
+	}
+
+	original := obj.DeepCopy()
+	obj.Object = result
+	debug.RecordIncrementalPatch(0, original, obj)
+	return obj, nil
+}
+
+// run calls the transform function of the script on the object. It returns
+// nil if the object is removed.
+func (s ScriptRule) run(obj *YamlObject) (map[string]interface{}, error) {
+	transform, err := CompileScript(s.Program)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := obj.Document()
+	if err != nil {
+		return nil, err
+	}
+	arg, err := toStarlark(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	thread := &starlark.Thread{Name: s.Describe().Name}
+	thread.SetMaxExecutionSteps(maxScriptSteps)
+	value, err := starlark.Call(thread, transform, starlark.Tuple{arg}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if value == starlark.None {
+		return nil, nil
+	}
+	result, err := fromStarlark(value)
+	if err != nil {
+		return nil, err
+	}
+	object, ok := result.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("transform returned a %s instead of a dict or None", value.Type())
+	}
+	return object, nil
+}
+
+// scripts caches the transform functions of scripts by source.
+var scripts sync.Map
+
+// CompileScript runs a Starlark program and returns its transform function.
+// The globals of the program are frozen, so that the function behaves the same
+// on every object.
+func CompileScript(program string) (starlark.Callable, error) {
+	if transform, ok := scripts.Load(program); ok {
+		return transform.(starlark.Callable), nil
+	}
+
+	// Without a Load function on the thread, load statements fail.
+	thread := &starlark.Thread{Name: "script"}
+	thread.SetMaxExecutionSteps(maxScriptSteps)
+	globals, err := starlark.ExecFile(thread, "script.star", program, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid script: %v", err)
+	}
+	globals.Freeze()
+	transform, ok := globals["transform"].(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("invalid script: it must define a function transform(object)")
+	}
+	scripts.Store(program, transform)
+	return transform, nil
+}
+
+// toStarlark converts a JSON value into a Starlark value. Whole numbers are
+// converted to ints.
+func toStarlark(value interface{}) (starlark.Value, error) {
+	switch v := value.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(v), nil
+	case string:
+		return starlark.String(v), nil
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return starlark.MakeInt64(int64(v)), nil
+		}
+		return starlark.Float(v), nil
+	case []interface{}:
+		elems := make([]starlark.Value, len(v))
+		for i, child := range v {
+			elem, err := toStarlark(child)
+			if err != nil {
+				return nil, err
+			}
+			elems[i] = elem
+		}
+		return starlark.NewList(elems), nil
+	case map[string]interface{}:
+		dict := starlark.NewDict(len(v))
+		for key, child := range v {
+			elem, err := toStarlark(child)
+			if err != nil {
+				return nil, err
+			}
+			if err := dict.SetKey(starlark.String(key), elem); err != nil {
+				return nil, err
+			}
+		}
+		return dict, nil
+	}
+	return nil, fmt.Errorf("unsupported value %v of type %T", value, value)
+}
+
+// fromStarlark converts a Starlark value back into a JSON value.
+func fromStarlark(value starlark.Value) (interface{}, error) {
+	switch v := value.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.String:
+		return string(v), nil
+	case starlark.Int:
+		i, ok := v.Int64()
+		if !ok {
+			return nil, fmt.Errorf("int %s is too large", v)
+		}
+		return i, nil
+	case starlark.Float:
+		return float64(v), nil
+	case starlark.Indexable:
+		// Lists and tuples.
+		result := make([]interface{}, v.Len())
+		for i := range result {
+			elem, err := fromStarlark(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			result[i] = elem
+		}
+		return result, nil
+	case *starlark.Dict:
+		result := make(map[string]interface{}, v.Len())
+		for _, item := range v.Items() {
+			key, ok := starlark.AsString(item[0])
+			if !ok {
+				return nil, fmt.Errorf("dict key %s is not a string", item[0])
+			}
+			elem, err := fromStarlark(item[1])
+			if err != nil {
+				return nil, err
+			}
+			result[key] = elem
+		}
+		return result, nil
+	}
+	return nil, fmt.Errorf("unsupported value %s of type %s", value, value.Type())
+}
@@ -0,0 +1,105 @@
+package differ
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestScriptRules(t *testing.T) {
+	apply := func(t *testing.T, rules string, objects []*YamlObject) ([]*YamlObject, *DebugInfo, error) {
+		var ruleSet RuleSet
+		require.NoError(t, yaml.Unmarshal([]byte(rules), &ruleSet))
+		ruleSet.Desugar()
+		debugInfo := NewDebugInfo(ruleSet)
+		debugInfo.AddInitialObjects(objects)
+		results, err := ApplyRuleSet(objects, ruleSet, debugInfo)
+		return results, debugInfo, err
+	}
+
+	t.Run("scripts modify objects", func(t *testing.T) {
+		results, debugInfo, err := apply(t, `
+rules:
+- script:
+    name: sort keys
+    match:
+    - {op: test, path: /kind, value: ConfigMap}
+    program: |
+      def transform(object):
+          object["data"]["keys"] = sorted(object["data"].keys())
+          return object
+`, []*YamlObject{newConfigMap("a", map[string]interface{}{"b": "1", "a": "2"})})
+		require.NoError(t, err)
+		require.NoError(t, debugInfo.ValidateAllRulesWereEffective())
+
+		value, err := results[0].Get("/data/keys")
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{"a", "b"}, value)
+	})
+
+	t.Run("scripts returning None remove objects", func(t *testing.T) {
+		results, debugInfo, err := apply(t, `
+rules:
+- script:
+    name: remove b
+    program: |
+      def transform(object):
+          if object["metadata"]["name"] == "b":
+              return None
+          return object
+`, []*YamlObject{newConfigMap("a", nil), newConfigMap("b", nil)})
+		require.NoError(t, err)
+		require.NoError(t, debugInfo.ValidateAllRulesWereEffective(), "removing an object is effective")
+		require.Len(t, results, 1)
+	})
+
+	t.Run("scripts changing nothing are ineffective", func(t *testing.T) {
+		_, debugInfo, err := apply(t, `
+rules:
+- script:
+    name: identity
+    program: |
+      def transform(object):
+          return object
+`, []*YamlObject{newConfigMap("a", map[string]interface{}{"replicas": 3})})
+		require.NoError(t, err)
+		err = debugInfo.ValidateAllRulesWereEffective()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `rule "identity" patching step 0`)
+	})
+
+	t.Run("scripts can't load files", func(t *testing.T) {
+		_, err := CompileScript(`
+load("/etc/passwd", "x")
+def transform(object):
+    return object
+`)
+		require.Error(t, err)
+	})
+
+	t.Run("scripts running forever fail", func(t *testing.T) {
+		_, _, err := apply(t, `
+rules:
+- script:
+    name: loop
+    program: |
+      def transform(object):
+          for i in range(1000000000):
+              pass
+          return object
+`, []*YamlObject{newConfigMap("a", nil)})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "too many steps")
+	})
+
+	t.Run("scripts without transform are reported when loading", func(t *testing.T) {
+		_, _, err := decodeRuleFile("rules.yaml", []byte(`
+rules:
+- script:
+    program: "x = 1"
+`), nil)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "rules.yaml:4:14: invalid script: it must define a function transform(object)")
+	})
+}
@@ -0,0 +1,146 @@
+package differ
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+// RuleSuggestion is a candidate patch rule together with the number of
+// remaining differences it would eliminate.
+type RuleSuggestion struct {
+	Rule        Json6902PatchRule
+	Differences int
+}
+
+// SuggestRules groups the remaining differences between paired objects by
+// path and value and proposes one patch rule per group:
+//
+//   - a field present on one side only becomes a remove_field rule
+//   - a value present on one side at one path and on the other side at
+//     another path of the same object becomes a rename_field rule
+//   - a value changed the same way in several objects becomes a replace step
+//     matching the old value
+//
+// Every rule is restricted to the kinds of the objects it was derived from
+// and marked as todo. Suggestions are ranked by the number of differences
+// they would remove. Differences inside embedded documents are not
+// considered, since they can't be addressed by a JSON pointer.
+func SuggestRules(diffs []ObjectDiff) []RuleSuggestion {
+	type group struct {
+		rule        Json6902PatchRule
+		kinds       map[string]bool
+		differences int
+	}
+	var groups = map[string]*group{}
+	var order []string
+	var add = func(key string, kind string, count int, newRule func() Json6902PatchRule) {
+		g, ok := groups[key]
+		if !ok {
+			g = &group{rule: newRule(), kinds: map[string]bool{}}
+			groups[key] = g
+			order = append(order, key)
+		}
+		g.kinds[kind] = true
+		g.differences += count
+	}
+
+	for _, od := range diffs {
+		kind := od.Identity.Kind
+		renamed := map[int]bool{}
+
+		// Look for values that moved to another path first, so that they are
+		// not also suggested for removal.
+		for i, l := range od.Differences {
+			if l.Type != DifferenceOnlyLeft || l.Embedded != "" {
+				continue
+			}
+			for j, r := range od.Differences {
+				// Redacted values all look the same.
+				if r.Type != DifferenceOnlyRight || r.Embedded != "" || renamed[j] || l.Redacted || r.Redacted || FormatValue(l.Left) != FormatValue(r.Right) {
+					continue
+				}
+				renamed[i], renamed[j] = true, true
+				from, to := l.Path, r.Path
+				add("rename "+from+" "+to, kind, 2, func() Json6902PatchRule {
+					return Json6902PatchRule{RenameField: &RenameRule{From: from, To: to}}
+				})
+				break
+			}
+		}
+
+		for i, d := range od.Differences {
+			if renamed[i] || d.Embedded != "" {
+				continue
+			}
+			switch d.Type {
+			case DifferenceOnlyLeft, DifferenceOnlyRight:
+				path := d.Path
+				add("remove "+path, kind, 1, func() Json6902PatchRule {
+					return Json6902PatchRule{RemoveField: path}
+				})
+			case DifferenceChanged:
+				if d.Redacted {
+					// The values needed by the rule are unknown.
+					continue
+				}
+				d := d
+				add("replace "+d.Path+" "+FormatValue(d.Left)+" "+FormatValue(d.Right), kind, 1, func() Json6902PatchRule {
+					return Json6902PatchRule{
+						Name: fmt.Sprintf("Replace %s with %s at %s", FormatValue(d.Left), FormatValue(d.Right), d.Path),
+						Steps: Json6902Patch{
+							{Op: "replace", Path: d.Path, Value: d.Right},
+						},
+						Matchers: map[string][]interface{}{
+							d.Path: {d.Left},
+						},
+					}
+				})
+			}
+		}
+	}
+
+	result := make([]RuleSuggestion, 0, len(order))
+	for _, key := range order {
+		g := groups[key]
+		kinds := make([]string, 0, len(g.kinds))
+		for kind := range g.kinds {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+
+		rule := g.rule
+		rule.Todo = true
+		if rule.Matchers == nil {
+			rule.Matchers = map[string][]interface{}{}
+		}
+		for _, kind := range kinds {
+			rule.Matchers["/kind"] = append(rule.Matchers["/kind"], kind)
+		}
+		result = append(result, RuleSuggestion{Rule: rule, Differences: g.differences})
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Differences > result[j].Differences
+	})
+	return result
+}
+
+// WriteRuleSuggestions prints the suggestions as patch rules that can be
+// pasted into a rule file.
+func WriteRuleSuggestions(w io.Writer, suggestions []RuleSuggestion) error {
+	fmt.Fprintln(w, "patch_rules:")
+	for _, s := range suggestions {
+		buf, err := yaml.Marshal([]Json6902PatchRule{s.Rule})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "# removes %d differences\n", s.Differences)
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
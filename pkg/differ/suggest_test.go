@@ -0,0 +1,68 @@
+package differ
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSuggestRules(t *testing.T) {
+	left := []*YamlObject{
+		newDeploymentWithLabels("querier", map[string]string{"helm.sh/chart": "mimir-3.0", "app.kubernetes.io/name": "querier"}),
+		newDeploymentWithLabels("distributor", map[string]string{"helm.sh/chart": "mimir-3.0", "app.kubernetes.io/name": "distributor"}),
+	}
+	right := []*YamlObject{
+		newDeploymentWithLabels("querier", map[string]string{"name": "querier"}),
+		newDeploymentWithLabels("distributor", map[string]string{"name": "distributor"}),
+	}
+	left[0].Object["spec"].(map[interface{}]interface{})["replicas"] = 3
+	right[0].Object["spec"].(map[interface{}]interface{})["replicas"] = 1
+
+	diffs, err := DiffObjectSets(left, right, DiffOptions{})
+	require.NoError(t, err)
+
+	suggestions := SuggestRules(diffs)
+
+	// Labels appear in the object labels, the selector and the pod template
+	// labels of both deployments. Renames remove two differences per object.
+	var renames, removes, replaces []RuleSuggestion
+	for _, s := range suggestions {
+		require.True(t, s.Rule.Todo)
+		require.Equal(t, []interface{}{"Deployment"}, s.Rule.Matchers["/kind"])
+		switch {
+		case s.Rule.RenameField != nil:
+			renames = append(renames, s)
+		case s.Rule.RemoveField != "":
+			removes = append(removes, s)
+		default:
+			replaces = append(replaces, s)
+		}
+	}
+
+	require.Len(t, renames, 3)
+	require.Equal(t, renames, suggestions[:3], "renames remove the most differences")
+	require.Equal(t, 4, renames[0].Differences)
+	require.Equal(t, "/metadata/labels/app.kubernetes.io~1name", renames[0].Rule.RenameField.From)
+	require.Equal(t, "/metadata/labels/name", renames[0].Rule.RenameField.To)
+
+	require.Len(t, removes, 3)
+	require.Equal(t, 2, removes[0].Differences)
+	require.Equal(t, "/metadata/labels/helm.sh~1chart", removes[0].Rule.RemoveField)
+
+	require.Len(t, replaces, 1)
+	require.Equal(t, 1, replaces[0].Differences)
+	require.Equal(t, "/spec/replicas", replaces[0].Rule.Steps[0].Path)
+	require.Equal(t, float64(1), replaces[0].Rule.Steps[0].Value)
+	require.Equal(t, []interface{}{float64(3)}, replaces[0].Rule.Matchers["/spec/replicas"])
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, WriteRuleSuggestions(buf, removes[:1]))
+	require.Equal(t, `patch_rules:
+# removes 2 differences
+- todo: true
+  remove_field: /metadata/labels/helm.sh~1chart
+  /kind:
+  - Deployment
+`, buf.String())
+}
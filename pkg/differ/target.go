@@ -0,0 +1,183 @@
+package differ
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Target selects objects like the target of kustomize patches. Group,
+// version, kind, name and namespace are regular expressions which must match
+// the whole value. The selectors are label selectors, e.g. "app=querier,
+// tier!=cache", on the labels and annotations of the object. Empty fields
+// match every object.
+type Target struct {
+	Group              string `yaml:"group,omitempty"`
+	Version            string `yaml:"version,omitempty"`
+	Kind               string `yaml:"kind,omitempty"`
+	Name               string `yaml:"name,omitempty"`
+	Namespace          string `yaml:"namespace,omitempty"`
+	LabelSelector      string `yaml:"labelSelector,omitempty"`
+	AnnotationSelector string `yaml:"annotationSelector,omitempty"`
+}
+
+// targetDescription describes a target as a condition of a rule.
+func targetDescription(t *Target) []string {
+	if t == nil {
+		return nil
+	}
+	return []string{"target: " + t.String()}
+}
+
+func (t Target) String() string {
+	var fields []string
+	for _, field := range []struct{ key, value string }{
+		{"group", t.Group},
+		{"version", t.Version},
+		{"kind", t.Kind},
+		{"name", t.Name},
+		{"namespace", t.Namespace},
+		{"labelSelector", t.LabelSelector},
+		{"annotationSelector", t.AnnotationSelector},
+	} {
+		if field.value != "" {
+			fields = append(fields, field.key+"="+field.value)
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// Validate checks that the regular expressions and selectors of the target
+// are valid.
+func (t Target) Validate() error {
+	_, err := t.compile()
+	return err
+}
+
+type compiledTarget struct {
+	group, version, kind, name, namespace *regexp.Regexp
+	labels, annotations                   labels.Selector
+}
+
+// compiledTargets caches compiled targets by value, since rules are
+// evaluated once per object.
+var compiledTargets sync.Map
+
+func (t Target) compile() (*compiledTarget, error) {
+	if c, ok := compiledTargets.Load(t); ok {
+		return c.(*compiledTarget), nil
+	}
+	var c compiledTarget
+	for _, field := range []struct {
+		key, value string
+		regexp     **regexp.Regexp
+	}{
+		{"group", t.Group, &c.group},
+		{"version", t.Version, &c.version},
+		{"kind", t.Kind, &c.kind},
+		{"name", t.Name, &c.name},
+		{"namespace", t.Namespace, &c.namespace},
+	} {
+		if field.value == "" {
+			continue
+		}
+		re, err := regexp.Compile("^(?:" + field.value + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid target %s %q: %v", field.key, field.value, err)
+		}
+		*field.regexp = re
+	}
+	for _, field := range []struct {
+		key, value string
+		selector   *labels.Selector
+	}{
+		{"labelSelector", t.LabelSelector, &c.labels},
+		{"annotationSelector", t.AnnotationSelector, &c.annotations},
+	} {
+		if field.value == "" {
+			continue
+		}
+		selector, err := labels.Parse(field.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid target %s %q: %v", field.key, field.value, err)
+		}
+		*field.selector = selector
+	}
+	compiledTargets.Store(t, &c)
+	return &c, nil
+}
+
+// Matches returns true if the object is selected by the target.
+func (t Target) Matches(obj *YamlObject) (bool, error) {
+	c, err := t.compile()
+	if err != nil {
+		return false, err
+	}
+
+	apiVersion, _ := obj.Object["apiVersion"].(string)
+	group, version := "", apiVersion
+	if i := strings.LastIndex(apiVersion, "/"); i >= 0 {
+		group, version = apiVersion[:i], apiVersion[i+1:]
+	}
+	id := ObjectIdentityForObject(obj)
+	for _, field := range []struct {
+		regexp *regexp.Regexp
+		value  string
+	}{
+		{c.group, group},
+		{c.version, version},
+		{c.kind, id.Kind},
+		{c.name, id.Name},
+		{c.namespace, id.Namespace},
+	} {
+		if field.regexp != nil && !field.regexp.MatchString(field.value) {
+			return false, nil
+		}
+	}
+
+	if c.labels != nil && !c.labels.Matches(labels.Set(stringMap(obj, "/metadata/labels"))) {
+		return false, nil
+	}
+	if c.annotations != nil && !c.annotations.Matches(labels.Set(stringMap(obj, "/metadata/annotations"))) {
+		return false, nil
+	}
+	return true, nil
+}
+
+// matchesTarget evaluates the target of a rule, recording a match as the
+// given condition. Rules without a target match every object.
+func matchesTarget(t *Target, obj *YamlObject, condition int, debug *RuleDebugInfo) (bool, error) {
+	if t == nil {
+		return true, nil
+	}
+	ok, err := t.Matches(obj)
+	if err != nil || !ok {
+		return false, err
+	}
+	debug.RecordConditionMatch(condition, obj)
+	return true, nil
+}
+
+// stringMap returns the map at a path of the object, with its values
+// formatted as strings.
+func stringMap(obj *YamlObject, path string) map[string]string {
+	value, err := obj.Get(path)
+	if err != nil {
+		return nil
+	}
+	result := map[string]string{}
+	switch m := value.(type) {
+	case map[string]interface{}:
+		for k, v := range m {
+			result[k] = fmt.Sprint(v)
+		}
+	case map[interface{}]interface{}:
+		for k, v := range m {
+			result[fmt.Sprint(k)] = fmt.Sprint(v)
+		}
+	}
+	return result
+}
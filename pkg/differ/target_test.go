@@ -0,0 +1,198 @@
+package differ
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestTargets(t *testing.T) {
+	newObject := func(doc string) *YamlObject {
+		obj := NewYamlObject("object.yaml")
+		require.NoError(t, DecodeYamlObject(strings.NewReader(doc), obj))
+		return obj
+	}
+
+	objects := func() []*YamlObject {
+		return []*YamlObject{
+			newObject(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: querier
+  namespace: mimir
+  labels:
+    app: querier
+    tier: read
+`),
+			newObject(`
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: ingester
+  namespace: mimir
+  labels:
+    tier: write
+  annotations:
+    rollout: zone-aware
+`),
+			newObject(`
+apiVersion: v1
+kind: Service
+metadata:
+  name: querier
+  namespace: mimir
+`),
+		}
+	}
+
+	names := func(objects []*YamlObject) []string {
+		var names []string
+		for _, obj := range objects {
+			id := ObjectIdentityForObject(obj)
+			names = append(names, id.Kind+"/"+id.Name)
+		}
+		return names
+	}
+
+	for _, tc := range []struct {
+		name   string
+		target string
+		kept   []string
+	}{
+		{"group", "{group: apps}", []string{"Service/querier"}},
+		{"version", "{version: v1, name: ingester}", []string{"Deployment/querier", "Service/querier"}},
+		{"kind regex", "{kind: Deployment|Service}", []string{"StatefulSet/ingester"}},
+		{"anchored name", "{name: quer}", []string{"Deployment/querier", "StatefulSet/ingester", "Service/querier"}},
+		{"name and namespace", "{name: quer.*, namespace: mimir}", []string{"StatefulSet/ingester"}},
+		{"label selector", "{labelSelector: 'tier in (read, write), app!=querier'}", []string{"Deployment/querier", "Service/querier"}},
+		{"annotation selector", "{annotationSelector: rollout=zone-aware}", []string{"Deployment/querier", "Service/querier"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var ruleSet RuleSet
+			require.NoError(t, yaml.Unmarshal([]byte("ignore_rules:\n- name: target\n  target: "+tc.target+"\n"), &ruleSet))
+			results, err := ApplyRuleSet(objects(), ruleSet, nil)
+			require.NoError(t, err)
+			require.Equal(t, tc.kept, names(results))
+		})
+	}
+
+	t.Run("targets are conditions of the rule", func(t *testing.T) {
+		var ruleSet RuleSet
+		require.NoError(t, yaml.Unmarshal([]byte(`
+patch_rules:
+- name: no match
+  target: {kind: DaemonSet}
+  steps:
+  - {op: remove, path: /metadata/labels}
+`), &ruleSet))
+		debugInfo := NewDebugInfo(ruleSet)
+		debugInfo.AddInitialObjects(objects())
+		_, err := ApplyRuleSet(objects(), ruleSet, debugInfo)
+		require.NoError(t, err)
+		err = debugInfo.ValidateAllRulesWereEffective()
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "target: kind=DaemonSet did not hold")
+	})
+
+	t.Run("targets are compiled once", func(t *testing.T) {
+		target := Target{Kind: "Deployment", LabelSelector: "app=querier"}
+		first, err := target.compile()
+		require.NoError(t, err)
+		second, err := target.compile()
+		require.NoError(t, err)
+		require.Same(t, first, second)
+	})
+
+	t.Run("invalid targets are reported when loading", func(t *testing.T) {
+		_, _, err := decodeRuleFile("rules.yaml", []byte(`
+patch_rules:
+- name: invalid
+  target:
+    name: "querier("
+`), nil)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `rules.yaml:5:5: invalid target name "querier("`)
+
+		_, _, err = decodeRuleFile("rules.yaml", []byte(`
+ignore_rules:
+- name: unknown field
+  target: {kind: Deployment, names: querier}
+`), nil)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "names")
+	})
+}
+
+func TestImportKustomization(t *testing.T) {
+	write := func(t *testing.T, dir, name, content string) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	t.Run("JSON 6902 patches become patch rules", func(t *testing.T) {
+		dir := t.TempDir()
+		write(t, dir, "kustomization.yaml", `
+resources:
+- querier.yaml
+patches:
+- target:
+    kind: Deployment
+    labelSelector: app=querier
+  patch: |-
+    - op: replace
+      path: /spec/replicas
+      value: 3
+patchesJson6902:
+- target:
+    group: apps
+    version: v1
+    kind: StatefulSet
+    name: ingester
+  path: ingester-patch.yaml
+`)
+		write(t, dir, "ingester-patch.yaml", `[{"op": "remove", "path": "/spec/template/spec/affinity"}]`)
+
+		rules, err := ImportKustomization(dir)
+		require.NoError(t, err)
+		require.Len(t, rules, 2)
+
+		require.Equal(t, "patches[0]", rules[0].Name)
+		require.Equal(t, &Target{Kind: "Deployment", LabelSelector: "app=querier"}, rules[0].Target)
+		require.Equal(t, Json6902Patch{{Op: "replace", Path: "/spec/replicas", Value: 3}}, rules[0].Steps)
+
+		require.Equal(t, "ingester-patch.yaml", rules[1].Name)
+		require.Equal(t, &Target{Group: "apps", Version: "v1", Kind: "StatefulSet", Name: "ingester"}, rules[1].Target)
+		require.Equal(t, Json6902Patch{{Op: "remove", Path: "/spec/template/spec/affinity"}}, rules[1].Steps)
+
+		// The printed rules are a valid rule file.
+		var out strings.Builder
+		require.NoError(t, WriteImportedRules(&out, rules))
+		ruleSet, _, err := decodeRuleFile("imported.yaml", []byte(out.String()), nil)
+		require.NoError(t, err)
+		require.Len(t, ruleSet.PatchRules, 2)
+	})
+
+	t.Run("strategic merge patches are not supported", func(t *testing.T) {
+		dir := t.TempDir()
+		write(t, dir, "kustomization.yaml", `
+patches:
+- path: replicas.yaml
+- target: {kind: Deployment}
+  patch: |-
+    apiVersion: apps/v1
+    kind: Deployment
+    metadata:
+      name: querier
+    spec:
+      replicas: 3
+`)
+		_, err := ImportKustomization(filepath.Join(dir, "kustomization.yaml"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "patches[0]: patches without a target")
+		require.Contains(t, err.Error(), "patches[1]: only JSON 6902 patches are supported")
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 
 	jsonpatch "github.com/evanphx/json-patch"
@@ -12,13 +13,20 @@ import (
 type DebugInfo struct {
 	RuleDebugInfos []*RuleDebugInfo
 	InitialObjects []*YamlObject
+
+	// Input is the name of the input this debug info was recorded for, see
+	// ForInput.
+	Input string
+
+	inputs     map[string]*DebugInfo
+	inputNames []string
 }
 
 func (d *DebugInfo) Print() {
 
 	var patchesByName = make(map[string][]objectPatch)
 
-	for _, ruleDebugInfo := range d.RuleDebugInfos {
+	for _, ruleDebugInfo := range d.allRuleDebugInfos() {
 		if !ruleDebugInfo.Rule.Describe().Todo {
 			continue
 		}
@@ -65,7 +73,7 @@ func printChanges(ruleName string, changes []objectPatch) {
 
 func NewDebugInfo(ruleSet RuleSet) *DebugInfo {
 	debugInfo := &DebugInfo{
-		RuleDebugInfos: make([]*RuleDebugInfo, len(ruleSet.IgnoreRules)+len(ruleSet.PatchRules)),
+		RuleDebugInfos: make([]*RuleDebugInfo, len(ruleSet.ObjectRules())),
 	}
 	return debugInfo
 }
@@ -74,15 +82,37 @@ func (d *DebugInfo) AddInitialObjects(objects []*YamlObject) {
 	d.InitialObjects = append(d.InitialObjects, objects...)
 }
 
+// ForInput returns the debug info recording the rules applied to the named
+// input, creating it on first use. The unnamed input is recorded in d itself.
+func (d *DebugInfo) ForInput(name string) *DebugInfo {
+	if d == nil || name == "" {
+		return d
+	}
+	if input, ok := d.inputs[name]; ok {
+		return input
+	}
+	if d.inputs == nil {
+		d.inputs = map[string]*DebugInfo{}
+	}
+	input := &DebugInfo{
+		RuleDebugInfos: make([]*RuleDebugInfo, len(d.RuleDebugInfos)),
+		Input:          name,
+	}
+	d.inputs[name] = input
+	d.inputNames = append(d.inputNames, name)
+	return input
+}
+
+// ValidateAllRulesWereEffective validates every rule on every input it was
+// applied to. A rule restricted to some inputs must be effective on each of
+// them, other rules only on at least one input.
 func (d *DebugInfo) ValidateAllRulesWereEffective() error {
 	if d == nil {
 		return nil
 	}
 	var multiError = new(MultiError)
-	for _, ruleDebugInfo := range d.RuleDebugInfos {
-		if err := ruleDebugInfo.ValidateAllStepsWereEffective(); err != nil {
-			multiError.Errors = append(multiError.Errors, err)
-		}
+	for i := range d.RuleDebugInfos {
+		multiError.Errors = append(multiError.Errors, d.validateRule(i)...)
 	}
 	if multiError.Errors != nil {
 		return multiError
@@ -90,6 +120,53 @@ func (d *DebugInfo) ValidateAllRulesWereEffective() error {
 	return nil
 }
 
+func (d *DebugInfo) validateRule(i int) []error {
+	var errs []error
+	var applied int
+	var scoped bool
+	for _, input := range append([]*DebugInfo{d}, d.inputDebugInfos()...) {
+		ruleDebugInfo := input.RuleDebugInfos[i]
+		if ruleDebugInfo == nil {
+			continue
+		}
+		applied++
+		scoped = len(ruleDebugInfo.Rule.Describe().Inputs) > 0
+		if err := ruleDebugInfo.ValidateAllStepsWereEffective(); err != nil {
+			if description := ruleDebugInfo.Rule.Describe(); description.Source != "" {
+				err = SourceError{Source: description.Source, Line: description.Line, Err: err}
+			}
+			if input.Input != "" {
+				err = InputError{Input: input.Input, Err: err}
+			}
+			errs = append(errs, err)
+		}
+	}
+	if !scoped && len(errs) < applied {
+		return nil
+	}
+	return errs
+}
+
+func (d *DebugInfo) inputDebugInfos() []*DebugInfo {
+	var result []*DebugInfo
+	for _, name := range d.inputNames {
+		result = append(result, d.inputs[name])
+	}
+	return result
+}
+
+func (d *DebugInfo) allRuleDebugInfos() []*RuleDebugInfo {
+	var result []*RuleDebugInfo
+	for _, input := range append([]*DebugInfo{d}, d.inputDebugInfos()...) {
+		for _, ruleDebugInfo := range input.RuleDebugInfos {
+			if ruleDebugInfo != nil {
+				result = append(result, ruleDebugInfo)
+			}
+		}
+	}
+	return result
+}
+
 func (d *DebugInfo) NewRuleDebugInfo(i int, rule ObjectRule) *RuleDebugInfo {
 	if d == nil {
 		return nil
@@ -98,10 +175,11 @@ func (d *DebugInfo) NewRuleDebugInfo(i int, rule ObjectRule) *RuleDebugInfo {
 	rdi := d.RuleDebugInfos[i]
 	if rdi == nil {
 		rdi = &RuleDebugInfo{
-			Parent:  d,
-			Rule:    rule,
-			Matches: make([]IncrementalMatchDebugInfo, len(rule.Describe().MatchRules)),
-			Patches: make([]IncrementalPatchDebugInfo, len(rule.Describe().PatchRules)),
+			Parent:     d,
+			Rule:       rule,
+			Matches:    make([]IncrementalMatchDebugInfo, len(rule.Describe().MatchRules)),
+			Conditions: make([]IncrementalMatchDebugInfo, len(rule.Describe().Conditions)),
+			Patches:    make([]IncrementalPatchDebugInfo, len(rule.Describe().PatchRules)),
 		}
 
 		d.RuleDebugInfos[i] = rdi
@@ -124,6 +202,17 @@ type RuleDebugInfo struct {
 	Matches []IncrementalMatchDebugInfo
 	Patches []IncrementalPatchDebugInfo
 	Ignored []*YamlObject
+
+	// Added are the objects added by the rule.
+	Added []*YamlObject
+
+	// Conditions record the objects which passed each condition evaluated
+	// after the match steps.
+	Conditions []IncrementalMatchDebugInfo
+
+	// ReferenceUpdates are the changes made to objects referring to an object
+	// renamed by the rule.
+	ReferenceUpdates []objectPatch
 }
 
 type MultiError struct {
@@ -139,6 +228,38 @@ func (e *MultiError) Error() string {
 	return sb.String()
 }
 
+// InputError is an error which occurred on a named input.
+type InputError struct {
+	Input string
+	Err   error
+}
+
+func (e InputError) Error() string {
+	return fmt.Sprintf("input %q: %s", e.Input, e.Err)
+}
+
+func (e InputError) Unwrap() error {
+	return e.Err
+}
+
+// SourceError is an error caused by a rule loaded from a rule file.
+type SourceError struct {
+	Source string
+	Line   int
+	Err    error
+}
+
+func (e SourceError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", e.Source, e.Line, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", e.Source, e.Err)
+}
+
+func (e SourceError) Unwrap() error {
+	return e.Err
+}
+
 type IneffectiveMatchError struct {
 	RuleName  string
 	Step      int
@@ -155,6 +276,21 @@ func (e IneffectiveMatchError) Error() string {
 	return fmt.Sprintf("rule %q matching step %d:\n\t %s did not match any objects in:\n\t\t%s", e.RuleName, e.Step, e.MatchRule, strings.Join(candidateStrings, "\n\t\t"))
 }
 
+type IneffectiveConditionError struct {
+	RuleName  string
+	Condition string
+	Matched   []*YamlObject
+}
+
+func (e IneffectiveConditionError) Error() string {
+	candidateStrings := []string{}
+	for _, u := range e.Matched {
+		candidateStrings = append(candidateStrings, ResourceKeyForObject(u).String())
+	}
+
+	return fmt.Sprintf("rule %q condition:\n\t %s did not hold for any objects in:\n\t\t%s", e.RuleName, e.Condition, strings.Join(candidateStrings, "\n\t\t"))
+}
+
 type IneffectivePatchError struct {
 	RuleName  string
 	Step      int
@@ -186,8 +322,28 @@ func (d *RuleDebugInfo) ValidateAllStepsWereEffective() error {
 		previousMatchedObjects = debugInfo.matchedObjects
 	}
 
-	// Validate that all patches changed at least one object.
+	// Validate that all conditions held for at least one object. Alternatives
+	// don't narrow down the objects the next condition is checked against.
+	for step, debugInfo := range d.Conditions {
+		if len(debugInfo.matchedObjects) == 0 {
+			return IneffectiveConditionError{
+				RuleName:  d.Rule.Describe().Name,
+				Condition: d.Rule.Describe().Conditions[step],
+				Matched:   previousMatchedObjects,
+			}
+		}
+		if !d.Rule.Describe().Alternatives[step] {
+			previousMatchedObjects = debugInfo.matchedObjects
+		}
+	}
+
+	// Validate that all patches changed at least one object. Removing or
+	// adding an object, as scripts and KRM functions may do, is a change as
+	// well.
 	for step, debugInfo := range d.Patches {
+		if len(d.Ignored) > 0 || len(d.Added) > 0 {
+			continue
+		}
 		if len(debugInfo.patchedObjects) == 0 {
 			return IneffectivePatchError{
 				RuleName:  d.Rule.Describe().Name,
@@ -229,6 +385,18 @@ func (d *RuleDebugInfo) Print() {
 		for _, u := range debugInfo.matchedObjects {
 			fmt.Printf("    %s\n", ResourceKeyForObject(u))
 		}
+		fmt.Printf("  Paths:\n")
+		for _, path := range debugInfo.Paths() {
+			fmt.Printf("    %s\n", path)
+		}
+	}
+
+	for step, debugInfo := range d.Conditions {
+		fmt.Printf("Condition: %s\n", d.Rule.Describe().Conditions[step])
+		fmt.Printf("  Matched:\n")
+		for _, u := range debugInfo.matchedObjects {
+			fmt.Printf("    %s\n", ResourceKeyForObject(u))
+		}
 	}
 
 	for step, debugInfo := range d.Patches {
@@ -237,6 +405,17 @@ func (d *RuleDebugInfo) Print() {
 		for _, op := range debugInfo.patchedObjects {
 			fmt.Printf("    %s -> %s\n", ResourceKeyForObject(op.oldObj), ResourceKeyForObject(op.newObj))
 		}
+		fmt.Printf("  Paths:\n")
+		for _, path := range debugInfo.Paths() {
+			fmt.Printf("    %s\n", path)
+		}
+	}
+
+	if len(d.ReferenceUpdates) > 0 {
+		fmt.Printf("Updated references:\n")
+		for _, op := range d.ReferenceUpdates {
+			fmt.Printf("    %s: %s\n", ResourceKeyForObject(op.newObj), op.patch)
+		}
 	}
 }
 
@@ -247,6 +426,26 @@ func (d *RuleDebugInfo) RecordIncrementalMatch(step int, obj *YamlObject) {
 	d.Matches[step].matchedObjects = append(d.Matches[step].matchedObjects, obj)
 }
 
+// MatchedObjects returns the objects which passed all match steps and
+// conditions of the rule.
+func (d *RuleDebugInfo) MatchedObjects() []*YamlObject {
+	if len(d.Conditions) > 0 {
+		return d.Conditions[len(d.Conditions)-1].matchedObjects
+	}
+	if len(d.Matches) > 0 {
+		return d.Matches[len(d.Matches)-1].matchedObjects
+	}
+	return d.Parent.InitialObjects
+}
+
+// RecordConditionMatch records that an object passed a condition of the rule.
+func (d *RuleDebugInfo) RecordConditionMatch(condition int, obj *YamlObject) {
+	if d == nil {
+		return
+	}
+	d.Conditions[condition].matchedObjects = append(d.Conditions[condition].matchedObjects, obj)
+}
+
 func (d *RuleDebugInfo) RecordIncrementalPatch(step int, oldObj, newObj *YamlObject) {
 	if d == nil {
 		return
@@ -258,16 +457,88 @@ func (d *RuleDebugInfo) RecordIncrementalPatch(step int, oldObj, newObj *YamlObj
 	})
 }
 
+// RecordIncrementalMatchPaths records the concrete paths a matching step
+// succeeded on. These differ from the path of the step if it has wildcards or
+// key predicates.
+func (d *RuleDebugInfo) RecordIncrementalMatchPaths(step int, paths []string) {
+	if d == nil {
+		return
+	}
+	d.Matches[step].paths = recordPaths(d.Matches[step].paths, paths)
+}
+
+// RecordIncrementalPatchPaths records the concrete paths a patching step was
+// applied to.
+func (d *RuleDebugInfo) RecordIncrementalPatchPaths(step int, paths []string) {
+	if d == nil {
+		return
+	}
+	d.Patches[step].paths = recordPaths(d.Patches[step].paths, paths)
+}
+
+func recordPaths(recorded map[string]bool, paths []string) map[string]bool {
+	if recorded == nil {
+		recorded = map[string]bool{}
+	}
+	for _, path := range paths {
+		recorded[path] = true
+	}
+	return recorded
+}
+
+func (d *RuleDebugInfo) RecordReferenceUpdate(oldObj, newObj *YamlObject) {
+	if d == nil {
+		return
+	}
+	d.ReferenceUpdates = append(d.ReferenceUpdates, objectPatch{
+		oldObj: oldObj.DeepCopy(),
+		newObj: newObj.DeepCopy(),
+		patch:  createPatch(oldObj, newObj),
+	})
+}
+
 func (d *RuleDebugInfo) RecordIgnore(obj *YamlObject) {
+	if d == nil {
+		return
+	}
 	d.Ignored = append(d.Ignored, obj)
 }
 
+// RecordAdd records an object added by the rule.
+func (d *RuleDebugInfo) RecordAdd(obj *YamlObject) {
+	if d == nil {
+		return
+	}
+	d.Added = append(d.Added, obj)
+}
+
 type IncrementalMatchDebugInfo struct {
 	matchedObjects []*YamlObject
+	paths          map[string]bool
+}
+
+// Paths returns the concrete paths the step matched on, sorted.
+func (i IncrementalMatchDebugInfo) Paths() []string {
+	return sortedPaths(i.paths)
 }
 
 type IncrementalPatchDebugInfo struct {
 	patchedObjects []objectPatch
+	paths          map[string]bool
+}
+
+// Paths returns the concrete paths the step was applied to, sorted.
+func (i IncrementalPatchDebugInfo) Paths() []string {
+	return sortedPaths(i.paths)
+}
+
+func sortedPaths(paths map[string]bool) []string {
+	result := make([]string, 0, len(paths))
+	for path := range paths {
+		result = append(result, path)
+	}
+	sort.Strings(result)
+	return result
 }
 
 type objectPatch struct {
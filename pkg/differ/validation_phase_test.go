@@ -107,3 +107,117 @@ func TestValidatePatches(t *testing.T) {
 		assert.Error(t, err, "the match step should be flagged as ineffective")
 	})
 }
+
+func TestValidateInputs(t *testing.T) {
+	newInputs := func() []Input {
+		return []Input{
+			{Name: "helm", Objects: []*YamlObject{newDeploymentWithLabels("querier", map[string]string{
+				"helm.sh/chart": "mimir-distributed",
+			})}},
+			{Name: "jsonnet", Objects: []*YamlObject{newDeploymentWithLabels("querier", nil)}},
+		}
+	}
+
+	apply := func(t *testing.T, rule Json6902PatchRule) (*DebugInfo, [][]*YamlObject) {
+		ruleSet := RuleSet{PatchRules: []Json6902PatchRule{rule}}
+		ruleSet.Desugar()
+		debugInfo := NewDebugInfo(ruleSet)
+		inputs := newInputs()
+		for _, input := range inputs {
+			debugInfo.ForInput(input.Name).AddInitialObjects(input.Objects)
+		}
+		results, err := ApplyRuleSetToInputs(inputs, ruleSet, debugInfo)
+		assert.NoError(t, err)
+		return debugInfo, results
+	}
+
+	t.Run("rules are only applied to their inputs", func(t *testing.T) {
+		debugInfo, _ := apply(t, Json6902PatchRule{
+			RemoveField: "/metadata/labels",
+			Inputs:      []string{"jsonnet"},
+		})
+
+		err := debugInfo.ValidateAllRulesWereEffective()
+		assert.Error(t, err, "the rule did nothing on the jsonnet input")
+		assert.Contains(t, err.Error(), `input "jsonnet"`)
+		assert.Nil(t, debugInfo.ForInput("helm").RuleDebugInfos[0], "the rule was not applied to the helm input")
+	})
+
+	t.Run("unrestricted rules must be effective on one input", func(t *testing.T) {
+		debugInfo, results := apply(t, Json6902PatchRule{RemoveField: "/metadata/labels"})
+
+		assert.NoError(t, debugInfo.ValidateAllRulesWereEffective())
+		for _, objects := range results {
+			labels, _ := objects[0].Get("/metadata/labels")
+			assert.Nil(t, labels)
+		}
+	})
+
+	t.Run("restricted rules must be effective on every input", func(t *testing.T) {
+		debugInfo, _ := apply(t, Json6902PatchRule{
+			RemoveField: "/metadata/labels",
+			Inputs:      []string{"helm", "jsonnet"},
+		})
+
+		err := debugInfo.ValidateAllRulesWereEffective()
+		assert.Error(t, err)
+		assert.NotContains(t, err.Error(), `input "helm"`)
+	})
+}
+
+func TestValidateMatchers(t *testing.T) {
+	apply := func(t *testing.T, rule Json6902PatchRule) error {
+		rules := Desugar(rule)
+		assert.Len(t, rules, 1, "matchers don't multiply rules")
+		ruleSet := RuleSet{PatchRules: rules}
+		objects := []*YamlObject{
+			newDeploymentWithLabels("querier", map[string]string{"app": "querier"}),
+			newConfigMap("ingester", nil),
+		}
+		debugInfo := NewDebugInfo(ruleSet)
+		debugInfo.AddInitialObjects(objects)
+		_, err := ApplyRuleSet(objects, ruleSet, debugInfo)
+		assert.NoError(t, err, "should not fail")
+		return debugInfo.ValidateAllRulesWereEffective()
+	}
+
+	rule := func() Json6902PatchRule {
+		return Json6902PatchRule{
+			Name:        "Remove namespaces",
+			RemoveField: "/metadata/namespace",
+			Matchers: map[string][]interface{}{
+				"/kind":          {"Deployment", "ConfigMap"},
+				"/metadata/name": {"ingester", "querier"},
+			},
+		}
+	}
+
+	t.Run("the rule as written must be effective", func(t *testing.T) {
+		// There is no Deployment named ingester, nor a ConfigMap named querier.
+		err := apply(t, rule())
+		assert.NoError(t, err, "the rule matched objects")
+	})
+
+	t.Run("each value must match with match_each_value", func(t *testing.T) {
+		r := rule()
+		r.MatchEachValue = true
+		assert.NoError(t, apply(t, r), "every value matched an object")
+
+		r.Matchers["/kind"] = append(r.Matchers["/kind"], "StatefulSet")
+		err := apply(t, r)
+		assert.Error(t, err, "no StatefulSet was matched")
+		assert.Contains(t, err.Error(), "/kind: StatefulSet did not hold")
+		// The value is checked against all objects which passed the match
+		// steps, not only those matching the previous value.
+		assert.Contains(t, err.Error(), "test-data")
+		assert.Contains(t, err.Error(), "ingester.yaml")
+	})
+
+	t.Run("a matcher whose values never match is ineffective", func(t *testing.T) {
+		r := rule()
+		r.Matchers["/kind"] = []interface{}{"StatefulSet", "DaemonSet"}
+		err := apply(t, r)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "/kind: one of [StatefulSet, DaemonSet] did not hold")
+	})
+}
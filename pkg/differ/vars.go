@@ -0,0 +1,103 @@
+package differ
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// varReference matches ${name} references to vars as well as $${, which
+// escapes a literal ${. References starting with a digit, such as the capture
+// group references ${1} of rename_object with regex, are not vars.
+var varReference = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_.-]*)\}`)
+
+// singleVarReference matches a string which is exactly one reference to a var.
+var singleVarReference = regexp.MustCompile(`^\$\{[A-Za-z_][A-Za-z0-9_.-]*\}$`)
+
+// ExpandVars replaces every ${name} in a string by the value of the var. It
+// fails if a var is undefined.
+func ExpandVars(s string, vars map[string]string) (string, error) {
+	var undefined []string
+	result := varReference.ReplaceAllStringFunc(s, func(match string) string {
+		if match == "$${" {
+			return "${"
+		}
+		name := match[2 : len(match)-1]
+		value, ok := vars[name]
+		if !ok {
+			undefined = append(undefined, name)
+			return match
+		}
+		return value
+	})
+	if len(undefined) > 0 {
+		return "", fmt.Errorf("undefined variable %q in %q", undefined[0], s)
+	}
+	return result, nil
+}
+
+// expandVarsInNode expands vars in all scalars of a rule file, including map
+// keys such as the paths of matchers. Errors carry the position of the scalar.
+// Expanded scalars are strings, except for unquoted scalars consisting of a
+// single reference, which get the type of the value of the var, so that
+// "replicas: ${replicas}" is a number if the var is.
+func expandVarsInNode(source string, node *yaml.Node, vars map[string]string) error {
+	if node.Kind == yaml.ScalarNode {
+		expanded, err := ExpandVars(node.Value, vars)
+		if err != nil {
+			return SchemaError{Source: source, Line: node.Line, Column: node.Column, Msg: err.Error()}
+		}
+		if expanded != node.Value {
+			if node.Style == 0 && singleVarReference.MatchString(node.Value) {
+				// An empty tag is resolved from the value.
+				node.Tag = ""
+			} else {
+				node.Tag = "!!str"
+			}
+			node.Value = expanded
+		}
+		return nil
+	}
+	for _, child := range node.Content {
+		if err := expandVarsInNode(source, child, vars); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeKey removes a key and its value from a mapping node.
+func removeKey(node *yaml.Node, key string) {
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+// mergeVars returns the vars of defaults overridden by those of overrides.
+func mergeVars(defaults, overrides map[string]string) map[string]string {
+	result := make(map[string]string, len(defaults)+len(overrides))
+	for k, v := range defaults {
+		result[k] = v
+	}
+	for k, v := range overrides {
+		result[k] = v
+	}
+	return result
+}
+
+// ParseVar parses a var given as key=value.
+func ParseVar(s string) (string, string, error) {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return "", "", fmt.Errorf("invalid var %q, expected key=value", s)
+	}
+	return s[:i], s[i+1:], nil
+}
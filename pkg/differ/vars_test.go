@@ -0,0 +1,112 @@
+package differ
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandVars(t *testing.T) {
+	vars := map[string]string{"cluster": "dev-01", "namespace": "cortex"}
+
+	for _, tc := range []struct {
+		input, expected string
+	}{
+		{"cortex-${cluster}", "cortex-dev-01"},
+		{"/${namespace}/${cluster}", "/cortex/dev-01"},
+		{"no vars", "no vars"},
+		{"mimir-$${cluster}", "mimir-${cluster}"},
+		{"capture ${1} and $1", "capture ${1} and $1"},
+	} {
+		t.Run(tc.input, func(t *testing.T) {
+			result, err := ExpandVars(tc.input, vars)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, result)
+		})
+	}
+
+	t.Run("undefined vars are errors", func(t *testing.T) {
+		_, err := ExpandVars("${env}", vars)
+		require.EqualError(t, err, `undefined variable "env" in "${env}"`)
+	})
+}
+
+func TestRuleFileVars(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		return path
+	}
+
+	writeFile("common.yaml", `
+vars:
+  prefix: cortex
+  cluster: default
+patch_rules:
+- rename_object:
+    from: ${prefix}-${cluster}-querier
+    to: querier
+  /metadata/labels/${prefix}: ["${cluster}"]
+`)
+	env := writeFile("env.yaml", `
+vars:
+  cluster: dev-01
+include: [common.yaml]
+`)
+
+	t.Run("vars of including files override vars of included files", func(t *testing.T) {
+		ruleSet, err := NewRuleLoader().LoadFile(env)
+		require.NoError(t, err)
+		ruleSet.Desugar()
+
+		rule := ruleSet.PatchRules[0]
+		require.Equal(t, "Rename cortex-dev-01-querier to querier", rule.Name)
+		require.Contains(t, rule.Match, Json6902Operation{Op: "test", Path: "/metadata/labels/cortex", Value: "dev-01"})
+	})
+
+	t.Run("loader vars override all vars", func(t *testing.T) {
+		loader := NewRuleLoader()
+		loader.Vars = map[string]string{"cluster": "prod-10", "prefix": "mimir"}
+		ruleSet, err := loader.LoadFile(env)
+		require.NoError(t, err)
+		ruleSet.Desugar()
+
+		require.Equal(t, "Rename mimir-prod-10-querier to querier", ruleSet.PatchRules[0].Name)
+	})
+
+	t.Run("undefined vars fail loading", func(t *testing.T) {
+		path := writeFile("undefined.yaml", `
+patch_rules:
+- remove_field: /metadata/labels/${label}
+`)
+		_, err := NewRuleLoader().LoadFile(path)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `undefined variable "label"`)
+	})
+	t.Run("unquoted single references take the type of the value", func(t *testing.T) {
+		path := writeFile("numbers.yaml", `
+vars:
+  replicas: 3
+patch_rules:
+- name: replicas-${replicas}
+  match:
+  - op: test
+    path: /spec/replicas
+    value: ${replicas}
+  /spec/replicas:
+  - ${replicas}
+  steps: [{op: add, path: /metadata/labels/replicas, value: "${replicas}"}]
+`)
+		ruleSet, err := NewRuleLoader().LoadFile(path)
+		require.NoError(t, err)
+
+		rule := ruleSet.PatchRules[0]
+		require.Equal(t, "replicas-3", rule.Name)
+		require.Equal(t, 3, rule.Match[0].Value)
+		require.Equal(t, []interface{}{3}, rule.Matchers["/spec/replicas"])
+		require.Equal(t, "3", rule.Steps[0].Value, "quoted references stay strings")
+	})
+}
@@ -1,8 +1,11 @@
 package differ
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 
 	jsoniter "github.com/json-iterator/go"
 	"github.com/mitchellh/copystructure"
@@ -82,6 +85,20 @@ func (obj *YamlObject) DeepCopy() *YamlObject {
 	return newObj.(*YamlObject)
 }
 
+// Document returns the object as a plain JSON value, with
+// map[string]interface{} for maps and float64 for numbers. This is the form
+// used when comparing objects.
+func (obj *YamlObject) Document() (interface{}, error) {
+	buf := new(bytes.Buffer)
+	err := EncodeYamlObjectAsJson(buf, obj)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	err = json.Unmarshal(buf.Bytes(), &doc)
+	return doc, err
+}
+
 func DecodeYamlObject(reader io.Reader, obj *YamlObject) error {
 	return yaml.NewDecoder(reader).Decode(&obj.Object)
 }
@@ -93,3 +110,24 @@ func EncodeYamlObject(writer io.Writer, obj *YamlObject) error {
 func EncodeYamlObjectAsJson(writer io.Writer, obj *YamlObject) error {
 	return jsoniter.ConfigCompatibleWithStandardLibrary.NewEncoder(writer).Encode(obj.Object)
 }
+
+// wholeNumbersToInts converts the whole float64 numbers of a document returned
+// by Document to int64, in place. Encoded as YAML, they keep their original
+// form instead of becoming e.g. 1e+06.
+func wholeNumbersToInts(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			v[key] = wholeNumbersToInts(child)
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = wholeNumbersToInts(child)
+		}
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int64(v)
+		}
+	}
+	return value
+}
@@ -0,0 +1,152 @@
+package lsp
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/grafana/k8s-diff/pkg/differ"
+)
+
+// Loader loads the rules and inputs the server analyzes, in the same way as
+// yaml-patch. It is called again whenever a rule file is opened or saved.
+type Loader func() (differ.RuleSet, []differ.Input, error)
+
+// Analysis is the result of applying the rules to the inputs.
+type Analysis struct {
+	RuleSet   differ.RuleSet
+	Inputs    []differ.Input
+	DebugInfo *differ.DebugInfo
+
+	// Err is the error loading the rules or applying them, if any, and
+	// Ineffective the rules which were not effective.
+	Err         error
+	Ineffective error
+
+	// Paths are the JSON pointers of every field of the input objects, with
+	// list indexes replaced by *.
+	Paths []string
+}
+
+// Analyze loads the rules and inputs and applies the rules, recording what
+// every rule matched.
+func Analyze(load Loader) *Analysis {
+	ruleSet, inputs, err := load()
+	if err != nil {
+		return &Analysis{Err: err}
+	}
+
+	a := &Analysis{RuleSet: ruleSet, Inputs: inputs, DebugInfo: differ.NewDebugInfo(ruleSet)}
+	paths := map[string]bool{}
+	for _, input := range inputs {
+		a.DebugInfo.ForInput(input.Name).AddInitialObjects(input.Objects)
+		for _, obj := range input.Objects {
+			collectPaths("", obj.Object, paths)
+		}
+	}
+	for path := range paths {
+		a.Paths = append(a.Paths, path)
+	}
+	sort.Strings(a.Paths)
+
+	if _, err := differ.ApplyRuleSetToInputs(inputs, ruleSet, a.DebugInfo); err != nil {
+		a.Err = err
+		return a
+	}
+	a.Ineffective = a.DebugInfo.ValidateAllRulesWereEffective()
+	return a
+}
+
+func collectPaths(prefix string, value interface{}, paths map[string]bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			collectPaths(prefix+"/"+escapePointerToken(key), child, paths)
+		}
+	case map[interface{}]interface{}:
+		for key, child := range v {
+			collectPaths(prefix+"/"+escapePointerToken(fmt.Sprint(key)), child, paths)
+		}
+	case []interface{}:
+		for _, child := range v {
+			collectPaths(prefix+"/*", child, paths)
+		}
+	}
+	if prefix != "" {
+		paths[prefix] = true
+	}
+}
+
+func escapePointerToken(token string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
+
+// RuleAt returns the indexes in the rule set of the rules defined at a line of
+// a rule file, given 1-based. The rule is the last one starting at or before
+// the line. A rule with matchers on several values is desugared into several
+// rules, all of which are returned.
+func (a *Analysis) RuleAt(path string, line int) []int {
+	var found []int
+	var foundLine int
+	for i, rule := range a.RuleSet.ObjectRules() {
+		description := rule.Describe()
+		if description.Line == 0 || description.Line > line || !samePath(description.Source, path) {
+			continue
+		}
+		if description.Line > foundLine {
+			found, foundLine = nil, description.Line
+		}
+		if description.Line == foundLine {
+			found = append(found, i)
+		}
+	}
+	return found
+}
+
+// InputMatches are the objects a rule matched in an input.
+type InputMatches struct {
+	Input   string
+	Objects []*differ.YamlObject
+}
+
+// Matches returns the objects the rules matched in each input they were
+// applied to.
+func (a *Analysis) Matches(rules []int) []InputMatches {
+	var result []InputMatches
+	if a.DebugInfo == nil {
+		return nil
+	}
+	for _, input := range a.Inputs {
+		debugInfo := a.DebugInfo.ForInput(input.Name)
+		matches := InputMatches{Input: input.Name}
+		seen := map[*differ.YamlObject]bool{}
+		applied := false
+		for _, i := range rules {
+			ruleDebugInfo := debugInfo.RuleDebugInfos[i]
+			if ruleDebugInfo == nil {
+				continue
+			}
+			applied = true
+			for _, obj := range ruleDebugInfo.MatchedObjects() {
+				if !seen[obj] {
+					seen[obj] = true
+					matches.Objects = append(matches.Objects, obj)
+				}
+			}
+		}
+		if applied {
+			result = append(result, matches)
+		}
+	}
+	return result
+}
+
+func samePath(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return absA == absB
+}
@@ -0,0 +1,376 @@
+// Package lsp implements a language server for yaml-patch rule files. It
+// applies the rules to the inputs like yaml-patch does and reports, in the
+// editor, what each rule matched and which rules were not effective.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/grafana/k8s-diff/pkg/differ"
+)
+
+var errMethodNotFound = errors.New("method not found")
+
+// Server is a language server speaking JSON-RPC over a stream, usually stdio.
+type Server struct {
+	load     Loader
+	analysis *Analysis
+
+	// documents are the unsaved contents of the open documents by URI, only
+	// used for completion: the analysis reads the saved files, so diagnostics
+	// and hovers are updated on save. published are the URIs diagnostics were
+	// last published for.
+	documents map[string]string
+	published map[string]bool
+
+	w        io.Writer
+	shutdown bool
+}
+
+// NewServer returns a server analyzing the rules and inputs returned by load.
+func NewServer(load Loader) *Server {
+	return &Server{
+		load:      load,
+		documents: map[string]string{},
+		published: map[string]bool{},
+	}
+}
+
+// Serve handles requests from r and writes responses and notifications to w
+// until the client exits.
+func (s *Server) Serve(r io.Reader, w io.Writer) error {
+	s.w = w
+	reader := bufio.NewReader(r)
+	for {
+		msg, err := readMessage(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return errors.New("exit before shutdown")
+			}
+			return nil
+		}
+		result, err := s.handle(msg)
+		if msg.ID == nil {
+			// Notifications have no response.
+			continue
+		}
+		response := &message{ID: msg.ID, Result: result}
+		if err != nil {
+			response.Result = nil
+			code := internalError
+			if errors.Is(err, errMethodNotFound) {
+				code = methodNotFound
+			}
+			response.Error = &responseError{Code: code, Message: err.Error()}
+		} else if result == nil {
+			response.Result = json.RawMessage("null")
+		}
+		if err := writeMessage(w, response); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) handle(msg *message) (interface{}, error) {
+	switch msg.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":   1,
+				"hoverProvider":      true,
+				"definitionProvider": true,
+				"completionProvider": map[string]interface{}{"triggerCharacters": []string{"/"}},
+			},
+			"serverInfo": map[string]string{"name": "yaml-patch"},
+		}, nil
+	case "initialized":
+		return nil, s.analyze()
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		s.documents[params.TextDocument.URI] = params.TextDocument.Text
+		return nil, s.analyze()
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		// Unsaved edits are not analyzed, applying the rules on every
+		// keystroke would run scripts and KRM functions just as often.
+		if n := len(params.ContentChanges); n > 0 {
+			s.documents[params.TextDocument.URI] = params.ContentChanges[n-1].Text
+		}
+		return nil, nil
+	case "textDocument/didSave":
+		return nil, s.analyze()
+	case "textDocument/didClose":
+		var params didOpenParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		delete(s.documents, params.TextDocument.URI)
+		return nil, nil
+	case "textDocument/hover":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.hover(params), nil
+	case "textDocument/definition":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.definition(params), nil
+	case "textDocument/completion":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.completion(params), nil
+	}
+	if strings.HasPrefix(msg.Method, "$/") || msg.ID == nil {
+		// Optional notifications can be ignored.
+		return nil, nil
+	}
+	return nil, fmt.Errorf("%w: %s", errMethodNotFound, msg.Method)
+}
+
+// analyze loads the rules and inputs again and publishes the diagnostics.
+func (s *Server) analyze() error {
+	if s.load == nil {
+		return nil
+	}
+	s.analysis = Analyze(s.load)
+
+	byURI := map[string][]diagnostic{}
+	for _, err := range flattenErrors(s.analysis.Err) {
+		uri, d := errorDiagnostic(err, severityError)
+		byURI[uri] = append(byURI[uri], d)
+	}
+	for _, err := range flattenErrors(s.analysis.Ineffective) {
+		uri, d := errorDiagnostic(err, severityWarning)
+		byURI[uri] = append(byURI[uri], d)
+	}
+	// Errors which can't be located, e.g. a missing input directory, are
+	// shown at the top of every open document.
+	if unlocated, ok := byURI[""]; ok {
+		delete(byURI, "")
+		for uri := range s.documents {
+			byURI[uri] = append(byURI[uri], unlocated...)
+		}
+	}
+
+	for uri := range s.published {
+		if _, ok := byURI[uri]; !ok {
+			byURI[uri] = []diagnostic{}
+		}
+	}
+	s.published = map[string]bool{}
+	uris := make([]string, 0, len(byURI))
+	for uri := range byURI {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	for _, uri := range uris {
+		if len(byURI[uri]) > 0 {
+			s.published[uri] = true
+		}
+		err := s.notify("textDocument/publishDiagnostics", map[string]interface{}{
+			"uri":         uri,
+			"diagnostics": byURI[uri],
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) notify(method string, params interface{}) error {
+	buf, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return writeMessage(s.w, &message{Method: method, Params: buf})
+}
+
+func flattenErrors(err error) []error {
+	var multiError *differ.MultiError
+	if errors.As(err, &multiError) {
+		var result []error
+		for _, err := range multiError.Errors {
+			result = append(result, flattenErrors(err)...)
+		}
+		return result
+	}
+	if err != nil {
+		return []error{err}
+	}
+	return nil
+}
+
+// errorDiagnostic locates an error in a rule file. Errors which can't be
+// located have an empty URI.
+func errorDiagnostic(err error, severity int) (string, diagnostic) {
+	d := diagnostic{Severity: severity, Source: "yaml-patch", Message: err.Error()}
+	var schemaError differ.SchemaError
+	if errors.As(err, &schemaError) {
+		d.Message = schemaError.Msg
+		d.Range = lineRange(schemaError.Line)
+		if schemaError.Column > 0 {
+			d.Range.Start.Character = schemaError.Column - 1
+		}
+		return pathToURI(schemaError.Source), d
+	}
+	var sourceError differ.SourceError
+	if errors.As(err, &sourceError) {
+		d.Message = sourceError.Err.Error()
+		var inputError differ.InputError
+		if errors.As(err, &inputError) {
+			d.Message = fmt.Sprintf("input %q: %s", inputError.Input, d.Message)
+		}
+		d.Range = lineRange(sourceError.Line)
+		return pathToURI(sourceError.Source), d
+	}
+	return "", d
+}
+
+// hover describes the objects matched by the rule under the cursor.
+func (s *Server) hover(params textDocumentPositionParams) interface{} {
+	rules := s.ruleAt(params)
+	if len(rules) == 0 {
+		return nil
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "**%s**\n", s.analysis.RuleSet.ObjectRules()[rules[0]].Describe().Name)
+	for _, matches := range s.analysis.Matches(rules) {
+		name := matches.Input
+		if name == "" {
+			name = "input"
+		}
+		fmt.Fprintf(&sb, "\n%s: %d objects\n", name, len(matches.Objects))
+		for _, obj := range matches.Objects {
+			fmt.Fprintf(&sb, "- `%s` (%s)\n", differ.ObjectIdentityForObject(obj), obj.ResourceKey)
+		}
+	}
+	return map[string]interface{}{
+		"contents": map[string]string{"kind": "markdown", "value": sb.String()},
+	}
+}
+
+// definition returns the files of the objects matched by the rule under the
+// cursor.
+func (s *Server) definition(params textDocumentPositionParams) interface{} {
+	rules := s.ruleAt(params)
+	result := []location{}
+	seen := map[string]bool{}
+	for _, matches := range s.analysis.Matches(rules) {
+		for _, obj := range matches.Objects {
+			source := obj.ResourceKey.Source
+			if source == "" || seen[source] {
+				continue
+			}
+			seen[source] = true
+			result = append(result, location{URI: pathToURI(source), Range: lineRange(1)})
+		}
+	}
+	return result
+}
+
+func (s *Server) ruleAt(params textDocumentPositionParams) []int {
+	if s.analysis == nil {
+		return nil
+	}
+	return s.analysis.RuleAt(uriToPath(params.TextDocument.URI), params.Position.Line+1)
+}
+
+// completion completes the JSON pointer before the cursor with the paths of
+// the input objects. Pointers end at whitespace, quotes and the ,{: of YAML
+// flow collections, but not at [, which also starts [key=value] predicates.
+func (s *Server) completion(params textDocumentPositionParams) interface{} {
+	items := []completionItem{}
+	if s.analysis == nil {
+		return items
+	}
+	lines := strings.Split(s.documents[params.TextDocument.URI], "\n")
+	if params.Position.Line >= len(lines) {
+		return items
+	}
+	if params.Position.Line < 0 || params.Position.Line >= len(lines) {
+		return items
+	}
+	line := lines[params.Position.Line]
+	end := byteOffset(line, params.Position.Character)
+	start := strings.LastIndexAny(line[:end], " \t\"',{:") + 1
+	// Pointers start with /, so a [ before it opens a flow sequence.
+	for start < end && line[start] == '[' {
+		start++
+	}
+	prefix := line[start:end]
+	if !strings.HasPrefix(prefix, "/") {
+		return items
+	}
+	replace := textRange{
+		Start: position{Line: params.Position.Line, Character: utf16Offset(line, start)},
+		End:   position{Line: params.Position.Line, Character: utf16Offset(line, end)},
+	}
+	for _, path := range s.analysis.Paths {
+		if strings.HasPrefix(path, prefix) {
+			items = append(items, completionItem{
+				Label:    path,
+				Kind:     completionKindField,
+				TextEdit: &textEdit{Range: replace, NewText: path},
+			})
+		}
+	}
+	return items
+}
+
+// byteOffset converts a character offset of the protocol, counted in UTF-16
+// code units, to a byte offset in line, clamped to the line.
+func byteOffset(line string, character int) int {
+	units := 0
+	for i, r := range line {
+		if units >= character {
+			return i
+		}
+		units += utf16Len(r)
+	}
+	return len(line)
+}
+
+// utf16Offset converts a byte offset in line to a character offset of the
+// protocol, counted in UTF-16 code units.
+func utf16Offset(line string, offset int) int {
+	units := 0
+	for _, r := range line[:offset] {
+		units += utf16Len(r)
+	}
+	return units
+}
+
+// utf16Len returns the number of UTF-16 code units encoding r.
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
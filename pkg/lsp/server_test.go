@@ -0,0 +1,262 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/grafana/k8s-diff/pkg/differ"
+	"github.com/stretchr/testify/require"
+)
+
+const testRules = `patch_rules:
+- name: rename querier
+  match:
+  - op: test
+    path: /metadata/name
+    value: querier
+  steps:
+  - op: replace
+    path: /metadata/name
+    value: mimir-querier
+- name: never matches
+  match:
+  - op: test
+    path: /metadata/name
+    value: missing
+`
+
+func writeTestFiles(t *testing.T) (rulesPath, inputDir string, load Loader) {
+	dir := t.TempDir()
+	rulesPath = filepath.Join(dir, "rules.yaml")
+	require.NoError(t, os.WriteFile(rulesPath, []byte(testRules), 0644))
+	inputDir = filepath.Join(dir, "input")
+	require.NoError(t, os.MkdirAll(inputDir, 0755))
+	for name, content := range map[string]string{
+		"querier.yaml":  "kind: Deployment\nmetadata:\n  name: querier\n  labels:\n    app.kubernetes.io/name: mimir\n",
+		"ingester.yaml": "kind: StatefulSet\nmetadata:\n  name: ingester\nspec:\n  containers:\n  - name: ingester\n",
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(inputDir, name), []byte(content), 0644))
+	}
+
+	load = func() (differ.RuleSet, []differ.Input, error) {
+		ruleSet, err := differ.NewRuleLoader().LoadFile(rulesPath)
+		if err != nil {
+			return differ.RuleSet{}, nil, err
+		}
+		ruleSet.Desugar()
+		objects, err := differ.ReadStateFromDirectory(inputDir)
+		if err != nil {
+			return differ.RuleSet{}, nil, err
+		}
+		return *ruleSet, []differ.Input{{Name: "new", Objects: objects}}, nil
+	}
+	return rulesPath, inputDir, load
+}
+
+type request struct {
+	id     int
+	method string
+	params interface{}
+}
+
+// session sends requests to a server, followed by shutdown and exit, and
+// returns the messages the server wrote. Requests with a zero id are
+// notifications.
+func session(t *testing.T, load Loader, requests ...request) []*message {
+	var in, out bytes.Buffer
+	requests = append(requests, request{id: 1000, method: "shutdown"}, request{method: "exit"})
+	for _, r := range requests {
+		msg := &message{Method: r.method}
+		if r.id != 0 {
+			id := json.RawMessage(fmt.Sprint(r.id))
+			msg.ID = &id
+		}
+		if r.params != nil {
+			buf, err := json.Marshal(r.params)
+			require.NoError(t, err)
+			msg.Params = buf
+		}
+		require.NoError(t, writeMessage(&in, msg))
+	}
+	require.NoError(t, NewServer(load).Serve(&in, &out))
+
+	var messages []*message
+	reader := bufio.NewReader(&out)
+	for {
+		msg, err := readMessage(reader)
+		if err == io.EOF {
+			return messages
+		}
+		require.NoError(t, err)
+		messages = append(messages, msg)
+	}
+}
+
+// response returns the result of the request with the given id, decoded into
+// a generic value.
+func response(t *testing.T, messages []*message, id int) interface{} {
+	for _, msg := range messages {
+		if msg.ID != nil && string(*msg.ID) == fmt.Sprint(id) {
+			require.Nil(t, msg.Error)
+			buf, err := json.Marshal(msg.Result)
+			require.NoError(t, err)
+			var result interface{}
+			require.NoError(t, json.Unmarshal(buf, &result))
+			return result
+		}
+	}
+	t.Fatalf("no response to request %d", id)
+	return nil
+}
+
+func positionParams(uri string, line, character int) map[string]interface{} {
+	return map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     map[string]int{"line": line, "character": character},
+	}
+}
+
+func TestServer(t *testing.T) {
+	rulesPath, inputDir, load := writeTestFiles(t)
+	uri := pathToURI(rulesPath)
+	open := request{method: "textDocument/didOpen", params: map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri, "text": testRules},
+	}}
+
+	t.Run("ineffective rules are reported on their line", func(t *testing.T) {
+		messages := session(t, load, request{id: 1, method: "initialize", params: map[string]interface{}{}}, open)
+
+		var diagnostics []*message
+		for _, msg := range messages {
+			if msg.Method == "textDocument/publishDiagnostics" {
+				diagnostics = append(diagnostics, msg)
+			}
+		}
+		require.Len(t, diagnostics, 1)
+		var params struct {
+			URI         string       `json:"uri"`
+			Diagnostics []diagnostic `json:"diagnostics"`
+		}
+		require.NoError(t, json.Unmarshal(diagnostics[0].Params, &params))
+		require.Equal(t, uri, params.URI)
+		require.Len(t, params.Diagnostics, 1)
+		require.Equal(t, 10, params.Diagnostics[0].Range.Start.Line)
+		require.Equal(t, severityWarning, params.Diagnostics[0].Severity)
+		require.Contains(t, params.Diagnostics[0].Message, "never matches")
+	})
+
+	t.Run("hover lists the matched objects", func(t *testing.T) {
+		messages := session(t, load, open, request{id: 1, method: "textDocument/hover", params: positionParams(uri, 3, 4)})
+
+		result := response(t, messages, 1).(map[string]interface{})
+		value := result["contents"].(map[string]interface{})["value"].(string)
+		require.Contains(t, value, "rename querier")
+		require.Contains(t, value, "new: 1 objects")
+		require.Contains(t, value, filepath.Join(inputDir, "querier.yaml"))
+		require.NotContains(t, value, "ingester")
+	})
+
+	t.Run("definition goes to the matched objects", func(t *testing.T) {
+		messages := session(t, load, open, request{id: 1, method: "textDocument/definition", params: positionParams(uri, 1, 0)})
+
+		locations := response(t, messages, 1).([]interface{})
+		require.Len(t, locations, 1)
+		require.Equal(t, pathToURI(filepath.Join(inputDir, "querier.yaml")), locations[0].(map[string]interface{})["uri"])
+	})
+
+	t.Run("pointer paths are completed from the inputs", func(t *testing.T) {
+		text := "patch_rules:\n- remove_field: /metadata/l"
+		messages := session(t, load,
+			request{method: "textDocument/didOpen", params: map[string]interface{}{
+				"textDocument": map[string]string{"uri": uri, "text": text},
+			}},
+			request{id: 1, method: "textDocument/completion", params: positionParams(uri, 1, 27)},
+			request{id: 2, method: "textDocument/completion", params: positionParams(uri, 1, 3)},
+		)
+
+		var labels []string
+		for _, item := range response(t, messages, 1).([]interface{}) {
+			labels = append(labels, item.(map[string]interface{})["label"].(string))
+		}
+		require.Equal(t, []string{"/metadata/labels", "/metadata/labels/app.kubernetes.io~1name"}, labels)
+		require.Empty(t, response(t, messages, 2), "only pointers are completed")
+	})
+
+	t.Run("completion positions are counted in UTF-16 code units", func(t *testing.T) {
+		// "é" is 2 bytes but 1 code unit, "🙂" 4 bytes but 2 code units.
+		text := "patch_rules:\n- {name: é🙂, remove_field: /metadata/l\n- remove_fields: [/metadata/l"
+		messages := session(t, load,
+			request{method: "textDocument/didOpen", params: map[string]interface{}{
+				"textDocument": map[string]string{"uri": uri, "text": text},
+			}},
+			request{id: 1, method: "textDocument/completion", params: positionParams(uri, 1, 39)},
+			request{id: 2, method: "textDocument/completion", params: positionParams(uri, 2, 29)},
+			request{id: 3, method: "textDocument/completion", params: positionParams(uri, 1, -1)},
+		)
+
+		replaced := func(id int) interface{} {
+			items := response(t, messages, id).([]interface{})
+			require.Len(t, items, 2)
+			return items[0].(map[string]interface{})["textEdit"].(map[string]interface{})["range"]
+		}
+		require.Equal(t, map[string]interface{}{
+			"start": map[string]interface{}{"line": float64(1), "character": float64(28)},
+			"end":   map[string]interface{}{"line": float64(1), "character": float64(39)},
+		}, replaced(1))
+		require.Equal(t, map[string]interface{}{
+			"start": map[string]interface{}{"line": float64(2), "character": float64(18)},
+			"end":   map[string]interface{}{"line": float64(2), "character": float64(29)},
+		}, replaced(2), "[ opening a flow sequence is not part of the pointer")
+		require.Empty(t, response(t, messages, 3), "negative positions don't panic")
+	})
+
+	t.Run("load errors are reported at their position", func(t *testing.T) {
+		broken := func() (differ.RuleSet, []differ.Input, error) {
+			return differ.RuleSet{}, nil, &differ.MultiError{Errors: []error{
+				differ.SchemaError{Source: rulesPath, Line: 2, Column: 3, Msg: `unknown field "nme" in patch rule`},
+			}}
+		}
+		messages := session(t, broken, open)
+
+		require.Len(t, messages, 2)
+		var params struct {
+			Diagnostics []diagnostic `json:"diagnostics"`
+		}
+		require.NoError(t, json.Unmarshal(messages[0].Params, &params))
+		require.Equal(t, []diagnostic{{
+			Range:    textRange{Start: position{Line: 1, Character: 2}, End: position{Line: 2}},
+			Severity: severityError,
+			Source:   "yaml-patch",
+			Message:  `unknown field "nme" in patch rule`,
+		}}, params.Diagnostics)
+	})
+
+	t.Run("unknown requests fail", func(t *testing.T) {
+		messages := session(t, load, request{id: 1, method: "workspace/symbol"})
+
+		require.Equal(t, methodNotFound, messages[0].Error.Code)
+	})
+}
+
+func TestCollectPaths(t *testing.T) {
+	paths := map[string]bool{}
+	collectPaths("", map[string]interface{}{
+		"spec": map[string]interface{}{
+			"containers": []interface{}{map[string]interface{}{"name": "a"}},
+		},
+		"a/b": "c",
+	}, paths)
+	require.Equal(t, map[string]bool{
+		"/spec":                   true,
+		"/spec/containers":        true,
+		"/spec/containers/*":      true,
+		"/spec/containers/*/name": true,
+		"/a~1b":                   true,
+	}, paths)
+}